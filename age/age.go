@@ -46,6 +46,11 @@ func AllPasswordEntries(forceReage bool, rcwPassword []byte) error {
 	now := time.Now()
 
 	for _, vanityPath := range allVanityPaths {
+		// attachments never contain passwords
+		if global.IsAttachment(vanityPath) {
+			continue
+		}
+
 		// ensure entry is not already aged (unless forcing re-age)
 		if !forceReage {
 			// ignore error; we only care if we can access the path or not
@@ -60,7 +65,7 @@ func AllPasswordEntries(forceReage bool, rcwPassword []byte) error {
 		if err != nil {
 			return err
 		}
-		if decSlice != nil && decSlice[0] != "" {
+		if decSlice != nil && decSlice[global.FieldPassword] != "" {
 			// calculate random UNIX timestamp from within the last 365 days
 			offsetInt, _ := rand.Int(rand.Reader, big.NewInt(31557600))
 			randomOffset := time.Duration(offsetInt.Int64()) * time.Second
@@ -0,0 +1,163 @@
+package backup
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/rwinkhart/libmutton/config"
+	"github.com/rwinkhart/libmutton/crypt"
+	"github.com/rwinkhart/libmutton/global"
+	"github.com/rwinkhart/libmutton/synccommon"
+)
+
+// Backup archive format (version 1):
+// magic + version byte, followed by an RCW-encrypted PAX tar archive containing:
+//   - entries/<vanity path>: entries, attachments, and folders (with mod times)
+//   - age/<age file name>: password age files (with mod times, which hold the age timestamps)
+//   - sanity.rcw: the RCW sanity check file
+//   - keygen: the key generation the sanity check file belongs to (see synccommon.GetKeyGeneration)
+//   - libmuttoncfg.json: the libmutton section of the config file (client-specific settings are excluded, as they may contain secrets)
+//   - manifest.json: archive metadata and the SHA-256 checksums of all of the above (always last)
+const (
+	magic         = "libmuttonbackup"
+	formatVersion = 1
+
+	entriesPrefix = "entries/"
+	agePrefix     = "age/"
+	sanityName    = "sanity.rcw"
+	keyGenName    = "keygen"
+	cfgName       = "libmuttoncfg.json"
+	manifestName  = "manifest.json"
+)
+
+// ManifestT describes the contents of a backup archive.
+type ManifestT struct {
+	FormatVersion    int               `json:"formatVersion"`
+	LibmuttonVersion string            `json:"libmuttonVersion"`
+	Created          int64             `json:"created"` // UNIX timestamp
+	Checksums        map[string]string `json:"checksums"`
+}
+
+// Create packs the entry directory, the password age directory, the RCW sanity check file
+// (and its key generation), and the non-secret parts of the config file into a single encrypted archive at backupPath.
+// Entry and age file mod times are preserved (they hold sync state and password age timestamps).
+// Revision history and device IDs are not included.
+// The archive is encrypted with the RCW password, so the
+// backup can only be restored with the password it was created with.
+// Leave rcwPassword nil to use RCW demonization.
+func Create(backupPath string, rcwPassword []byte) error {
+	var buffer bytes.Buffer
+	writer := tar.NewWriter(&buffer)
+	manifest := &ManifestT{FormatVersion: formatVersion, LibmuttonVersion: global.LibmuttonVersion, Created: time.Now().Unix(), Checksums: make(map[string]string)}
+
+	// addFile adds a file (or, if isDir is true, a directory) to the archive and records its checksum
+	addFile := func(name string, data []byte, modTime time.Time, isDir bool) error {
+		header := &tar.Header{Name: name, ModTime: modTime, Mode: 0600, Size: int64(len(data)), Typeflag: tar.TypeReg, Format: tar.FormatPAX}
+		if isDir {
+			header.Mode, header.Typeflag = 0700, tar.TypeDir
+		} else if name != manifestName {
+			checksum := sha256.Sum256(data)
+			manifest.Checksums[name] = hex.EncodeToString(checksum[:])
+		}
+		if err := writer.WriteHeader(header); err != nil {
+			return errors.New("unable to add \"" + name + "\" to backup: " + err.Error())
+		}
+		if _, err := writer.Write(data); err != nil {
+			return errors.New("unable to add \"" + name + "\" to backup: " + err.Error())
+		}
+		return nil
+	}
+
+	// addDir adds the contents of a directory to the archive (with the given prefix)
+	addDir := func(dir, prefix string) error {
+		return filepath.WalkDir(dir, func(realPath string, entry fs.DirEntry, err error) error {
+			if err != nil {
+				return errors.New("unable to walk \"" + dir + "\": " + err.Error())
+			}
+			if realPath == dir {
+				return nil
+			}
+			info, err := entry.Info()
+			if err != nil {
+				return errors.New("unable to read \"" + realPath + "\": " + err.Error())
+			}
+			name := prefix + filepath.ToSlash(realPath[len(dir)+1:])
+			if entry.IsDir() {
+				return addFile(name+"/", nil, info.ModTime(), true)
+			}
+			data, err := os.ReadFile(realPath)
+			if err != nil {
+				return errors.New("unable to read \"" + realPath + "\": " + err.Error())
+			}
+			return addFile(name, data, info.ModTime(), false)
+		})
+	}
+
+	if err := addDir(global.EntryRoot, entriesPrefix); err != nil {
+		return err
+	}
+	if _, err := os.Stat(global.AgeDir); err == nil {
+		if err = addDir(global.AgeDir, agePrefix); err != nil {
+			return err
+		}
+	}
+
+	// sanity check file
+	sanityPath := global.CfgDir + global.PathSeparator + sanityName
+	sanityBytes, err := os.ReadFile(sanityPath)
+	if err != nil {
+		return errors.New("unable to read sanity check file: " + err.Error())
+	}
+	if err = addFile(sanityName, sanityBytes, time.Now(), false); err != nil {
+		return err
+	}
+
+	// key generation (restored along with the sanity check file so that syncing detects password changes correctly)
+	keyGeneration, err := synccommon.GetKeyGeneration()
+	if err != nil {
+		return err
+	}
+	if err = addFile(keyGenName, []byte(strconv.FormatInt(keyGeneration, 10)), time.Now(), false); err != nil {
+		return err
+	}
+
+	// config file (libmutton section only)
+	if cfg, err := config.Load(); err == nil {
+		cfg.ClientSpecific = nil
+		cfgBytes, err := json.MarshalIndent(cfg, "", "  ")
+		if err != nil {
+			return errors.New("unable to marshal config: " + err.Error())
+		}
+		if err = addFile(cfgName, cfgBytes, time.Now(), false); err != nil {
+			return err
+		}
+	}
+
+	// manifest (checksums cover all preceding files)
+	manifestBytes, err := json.Marshal(manifest)
+	if err != nil {
+		return errors.New("unable to marshal manifest: " + err.Error())
+	}
+	if err = addFile(manifestName, manifestBytes, time.Now(), false); err != nil {
+		return err
+	}
+	if err = writer.Close(); err != nil {
+		return errors.New("unable to finalize backup: " + err.Error())
+	}
+
+	// clone rcwPassword, as it is zeroized after use (nil is preserved for RCW demonization)
+	encBytes := crypt.EncryptBytes(buffer.Bytes(), bytes.Clone(rcwPassword))
+	if err = os.WriteFile(backupPath, append(append([]byte(magic), formatVersion), encBytes...), 0600); err != nil {
+		return errors.New("unable to write backup: " + err.Error())
+	}
+	return nil
+}
@@ -0,0 +1,303 @@
+package backup
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rwinkhart/libmutton/config"
+	"github.com/rwinkhart/libmutton/global"
+	"github.com/rwinkhart/libmutton/synccommon"
+	"github.com/rwinkhart/rcw/wrappers"
+)
+
+// archiveFileT is a file or directory read from a backup archive.
+type archiveFileT struct {
+	name    string
+	data    []byte
+	modTime time.Time
+	isDir   bool
+}
+
+// archiveT is the verified contents of a backup archive.
+type archiveT struct {
+	manifest *ManifestT
+	files    []archiveFileT // entries and age files, in archive order
+	sanity   []byte
+	keyGen   int64  // key generation of the sanity check file
+	cfg      []byte // nil if the config file was not backed up
+}
+
+// Verify decrypts the backup archive at backupPath and ensures it is intact
+// (all checksums match) and consistent with rcwPassword (the RCW sanity check passes).
+// rcwPassword must be the RCW password the backup was created with (RCW demonization
+// is not supported, as the cached password belongs to the current entries).
+// Returns: the backup's manifest.
+func Verify(backupPath string, rcwPassword []byte) (*ManifestT, error) {
+	archive, err := readArchive(backupPath, rcwPassword)
+	if err != nil {
+		return nil, err
+	}
+	return archive.manifest, nil
+}
+
+// Restore replaces the entry directory, the password age directory, the RCW sanity check
+// file (and its key generation), and the libmutton section of the config file with the contents of the backup
+// archive at backupPath (client-specific settings, revision history, and device IDs are left as-is).
+// The archive is fully verified (see Verify) before anything is replaced.
+// rcwPassword must be the RCW password the backup was created with.
+func Restore(backupPath string, rcwPassword []byte) error {
+	archive, err := readArchive(backupPath, rcwPassword)
+	if err != nil {
+		return err
+	}
+
+	// ensure libmutton directories exist (e.g. when restoring on a new device)
+	if _, err = global.DirInit(true); err != nil {
+		return errors.New("unable to initialize libmutton directories: " + err.Error())
+	}
+	oldKeyGeneration, err := synccommon.GetKeyGeneration()
+	if err != nil {
+		return err
+	}
+
+	// stage the restored directories next to the current ones
+	dirRoots := map[string]string{entriesPrefix: global.EntryRoot, agePrefix: global.AgeDir}
+	for _, dirRoot := range dirRoots {
+		if err = os.RemoveAll(dirRoot + "-restore"); err != nil {
+			return errors.New("unable to remove \"" + dirRoot + "-restore\": " + err.Error())
+		}
+		if err = os.MkdirAll(dirRoot+"-restore", 0700); err != nil {
+			return errors.New("unable to create \"" + dirRoot + "-restore\": " + err.Error())
+		}
+	}
+	for _, file := range archive.files {
+		prefix := entriesPrefix
+		if strings.HasPrefix(file.name, agePrefix) {
+			prefix = agePrefix
+		}
+		realPath := dirRoots[prefix] + "-restore" + global.PathSeparator + strings.ReplaceAll(strings.TrimSuffix(file.name[len(prefix):], "/"), "/", global.PathSeparator)
+		if file.isDir {
+			if err = os.MkdirAll(realPath, 0700); err != nil {
+				return errors.New("unable to create \"" + realPath + "\": " + err.Error())
+			}
+			continue
+		}
+		if err = os.WriteFile(realPath, file.data, 0600); err != nil {
+			return errors.New("unable to write \"" + realPath + "\": " + err.Error())
+		}
+		if err = os.Chtimes(realPath, time.Now(), file.modTime); err != nil {
+			return errors.New("unable to set mod time on \"" + realPath + "\": " + err.Error())
+		}
+	}
+	sanityPath := global.CfgDir + global.PathSeparator + sanityName
+	if err = os.WriteFile(sanityPath+"-restore", archive.sanity, 0600); err != nil {
+		return errors.New("unable to write sanity check file: " + err.Error())
+	}
+
+	// swap the restored directories with the current ones
+	// if any swap fails, the completed ones are rolled back so that the restored
+	// entries are never left in place alongside the current age data (or vice versa)
+	var swapped []string
+	rollBack := func() {
+		for _, dirRoot := range slices.Backward(swapped) {
+			_ = os.Rename(dirRoot, dirRoot+"-restore")
+			_ = os.Rename(dirRoot+"-old", dirRoot)
+		}
+	}
+	for _, dirRoot := range []string{global.EntryRoot, global.AgeDir} {
+		if err = os.RemoveAll(dirRoot + "-old"); err != nil {
+			rollBack()
+			return errors.New("unable to remove \"" + dirRoot + "-old\": " + err.Error())
+		}
+		if err = os.Rename(dirRoot, dirRoot+"-old"); err != nil {
+			rollBack()
+			return errors.New("unable to rename current directory: " + err.Error())
+		}
+		if err = os.Rename(dirRoot+"-restore", dirRoot); err != nil {
+			_ = os.Rename(dirRoot+"-old", dirRoot) // put the current directory back in place
+			rollBack()
+			return errors.New("unable to rename restored directory: " + err.Error())
+		}
+		swapped = append(swapped, dirRoot)
+	}
+	if err = synccommon.SetKeyGeneration(archive.keyGen); err != nil {
+		rollBack()
+		return err
+	}
+	if err = os.Rename(sanityPath+"-restore", sanityPath); err != nil {
+		_ = synccommon.SetKeyGeneration(oldKeyGeneration)
+		rollBack()
+		return errors.New("unable to replace sanity check file: " + err.Error())
+	}
+
+	// restore the libmutton section of the config file (preserving client-specific settings)
+	if archive.cfg != nil {
+		var restoredCfg config.CfgT
+		if err = json.Unmarshal(archive.cfg, &restoredCfg); err != nil {
+			return errors.New("unable to unmarshal backed up config: " + err.Error())
+		}
+		if currentCfg, err := config.Load(); err == nil {
+			restoredCfg.ClientSpecific = currentCfg.ClientSpecific
+		}
+		if err = config.Write(&restoredCfg, false); err != nil {
+			return err
+		}
+	}
+
+	// remove the replaced directories
+	for _, dirRoot := range []string{global.EntryRoot, global.AgeDir} {
+		if err = os.RemoveAll(dirRoot + "-old"); err != nil {
+			return errors.New("unable to remove \"" + dirRoot + "-old\": " + err.Error())
+		}
+	}
+	return nil
+}
+
+// readArchive decrypts, parses, and verifies the backup archive at backupPath.
+func readArchive(backupPath string, rcwPassword []byte) (*archiveT, error) {
+	if len(rcwPassword) == 0 {
+		return nil, errors.New("RCW password cannot be empty")
+	}
+	fileBytes, err := os.ReadFile(backupPath)
+	if err != nil {
+		return nil, errors.New("unable to read backup: " + err.Error())
+	}
+	if !bytes.HasPrefix(fileBytes, []byte(magic)) || len(fileBytes) <= len(magic) {
+		return nil, errors.New("file is not a libmutton backup")
+	}
+	if version := fileBytes[len(magic)]; version != formatVersion {
+		return nil, errors.New("unsupported backup format version (" + strconv.Itoa(int(version)) + ")")
+	}
+	// clone rcwPassword, as it is zeroized after use
+	decBytes, err := wrappers.Decrypt(fileBytes[len(magic)+1:], bytes.Clone(rcwPassword), true)
+	if err != nil {
+		return nil, errors.New("unable to decrypt backup (likely due to an incorrect password or corruption): " + err.Error())
+	}
+
+	// read archive contents
+	archive := &archiveT{}
+	checksums := make(map[string]string)
+	seen := make(map[string]bool)
+	reader := tar.NewReader(bytes.NewReader(decBytes))
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, errors.New("unable to read backup: " + err.Error())
+		}
+		name := header.Name
+		if !cleanArchiveName(name) || seen[name] {
+			return nil, errors.New("backup contains an invalid or duplicate path (\"" + name + "\")")
+		}
+		seen[name] = true
+		if archive.manifest != nil {
+			return nil, errors.New("backup contains data after its manifest")
+		}
+		isDir := header.Typeflag == tar.TypeDir
+		if !isDir && header.Typeflag != tar.TypeReg {
+			return nil, errors.New("backup contains an unsupported file type (\"" + name + "\")")
+		}
+		data, err := io.ReadAll(reader)
+		if err != nil {
+			return nil, errors.New("unable to read \"" + name + "\" from backup: " + err.Error())
+		}
+		if !isDir && name != manifestName {
+			checksum := sha256.Sum256(data)
+			checksums[name] = hex.EncodeToString(checksum[:])
+		}
+
+		switch {
+		case name == manifestName:
+			archive.manifest = &ManifestT{}
+			if err = json.Unmarshal(data, archive.manifest); err != nil {
+				return nil, errors.New("unable to parse backup manifest: " + err.Error())
+			}
+		case name == sanityName:
+			archive.sanity = data
+		case name == keyGenName:
+			if archive.keyGen, err = strconv.ParseInt(string(data), 10, 64); err != nil {
+				return nil, errors.New("unable to parse backed up key generation: " + err.Error())
+			}
+		case name == cfgName:
+			archive.cfg = data
+		case strings.HasPrefix(name, entriesPrefix) && len(name) > len(entriesPrefix),
+			strings.HasPrefix(name, agePrefix) && len(name) > len(agePrefix) && !isDir:
+			archive.files = append(archive.files, archiveFileT{name: name, data: data, modTime: header.ModTime, isDir: isDir})
+		default:
+			return nil, errors.New("backup contains an unexpected path (\"" + name + "\")")
+		}
+	}
+
+	// verify integrity
+	if archive.manifest == nil {
+		return nil, errors.New("backup is incomplete (missing manifest)")
+	}
+	if archive.manifest.FormatVersion != formatVersion {
+		return nil, errors.New("backup manifest has an unsupported format version (" + strconv.Itoa(archive.manifest.FormatVersion) + ")")
+	}
+	if len(checksums) != len(archive.manifest.Checksums) {
+		return nil, errors.New("backup is incomplete (file count does not match manifest)")
+	}
+	for name, checksum := range checksums {
+		if archive.manifest.Checksums[name] != checksum {
+			return nil, errors.New("backup is corrupt (checksum mismatch for \"" + name + "\")")
+		}
+	}
+
+	// ensure the backed up entries are encrypted with rcwPassword
+	if archive.sanity == nil {
+		return nil, errors.New("backup is incomplete (missing sanity check file)")
+	}
+	if err = runSanityCheck(archive.sanity, rcwPassword); err != nil {
+		return nil, err
+	}
+	return archive, nil
+}
+
+// runSanityCheck runs the RCW sanity check on the (backed up) sanity check file contents.
+func runSanityCheck(sanity, rcwPassword []byte) error {
+	f, err := os.CreateTemp("", "libmutton-sanity-*")
+	if err != nil {
+		return errors.New("unable to create temporary sanity check file: " + err.Error())
+	}
+	defer func(name string) {
+		_ = os.Remove(name)
+	}(f.Name())
+	_, err = f.Write(sanity)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return errors.New("unable to write temporary sanity check file: " + err.Error())
+	}
+	if err = wrappers.RunSanityCheck(f.Name(), rcwPassword); err != nil {
+		return errors.New("backup does not match RCW password: " + err.Error())
+	}
+	return nil
+}
+
+// cleanArchiveName returns whether name is a relative, clean path (to prevent writing outside the restore directories).
+func cleanArchiveName(name string) bool {
+	trimmed := strings.TrimSuffix(name, "/")
+	if trimmed == "" || strings.HasPrefix(trimmed, "/") || strings.Contains(trimmed, "\\") {
+		return false
+	}
+	for _, element := range strings.Split(trimmed, "/") {
+		if element == "" || element == "." || element == ".." {
+			return false
+		}
+	}
+	return true
+}
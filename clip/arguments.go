@@ -3,41 +3,66 @@
 package clip
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
 	"os"
 
 	"github.com/rwinkhart/go-boilerplate/back"
+	"github.com/rwinkhart/libmutton/core"
 	"github.com/rwinkhart/libmutton/crypt"
+	"github.com/rwinkhart/libmutton/global"
 )
 
 // CopyShortcut (given a path) decrypts an
 // entry and copies a field to the clipboard.
+// If customFieldName is not empty, the named custom field is copied instead (field is ignored).
 // Leave rcwPassword nil to use RCW demonization.
-func CopyShortcut(realPath string, field int, rcwPassword []byte) error {
+func CopyShortcut(realPath string, field int, customFieldName string, rcwPassword []byte) error {
 	// ensure realPath exists and is a file
 	_, err := back.TargetIsFile(realPath, true)
 	if err != nil {
 		return err
 	}
 
-	// decrypt entry
-	decSlice, err := crypt.DecryptFileToSlice(realPath, rcwPassword)
+	// decrypt entry (clone rcwPassword, as it may be needed again for HOTP; nil is preserved for RCW demonization)
+	decSlice, err := crypt.DecryptFileToSlice(realPath, bytes.Clone(rcwPassword))
 	if err != nil {
 		return errors.New("unable to decrypt entry: " + err.Error())
 	}
 
+	// custom field mode
+	if customFieldName != "" {
+		customField := core.GetCustomField(core.ParseEntry(decSlice), customFieldName)
+		if customField == nil {
+			return errors.New("custom field \"" + customFieldName + "\" does not exist in entry")
+		}
+		if customField.Value == "" {
+			return errors.New("field is empty")
+		}
+		// copy field to clipboard; launch clipboard clearing process
+		return CopyBytes(true, []byte(customField.Value))
+	}
+
 	// if field exists in entry...
 	if len(decSlice) > field {
 		if decSlice[field] == "" {
 			return errors.New("field is empty")
 		}
 
-		if field == 2 { // TOTP mode
+		if field == global.FieldTOTP { // TOTP mode
+			if params, err := core.ParseTOTP(decSlice[global.FieldTOTP]); err == nil && params.IsHOTP {
+				// HOTP tokens are single-use; generate one (persisting the counter) and copy it
+				token, err := core.GenHOTP(realPath, rcwPassword)
+				if err != nil {
+					return err
+				}
+				return CopyBytes(true, []byte(token))
+			}
 			fmt.Println(back.AnsiWarning + "[Starting]" + back.AnsiReset + " TOTP clipboard refresher")
 			errorChan := make(chan error, 1)
-			go TOTPCopier(decSlice[2], errorChan, nil) // "done" is not needed because the process runs until the program is killed
-			if err = <-errorChan; err != nil {         // handle error from first copy
+			go TOTPCopier(decSlice[global.FieldTOTP], errorChan, nil) // "done" is not needed because the process runs until the program is killed
+			if err = <-errorChan; err != nil {                        // handle error from first copy
 				return errors.New("error encountered in TOTP refresh process: " + err.Error())
 			}
 			select {} // block indefinitely
@@ -16,6 +16,12 @@ import (
 // iteration, as subsequent errors are highly unlikely to occur
 // and allowing the caller to move on from error-checking is beneficial.
 func TOTPCopier(secret string, errorChan chan<- error, done <-chan bool) {
+	// determine the refresh period (falls back to 30 seconds; errors are reported by GenTOTP)
+	var period int64 = 30
+	if params, err := core.ParseTOTP(secret); err == nil && params.Period > 0 {
+		period = int64(params.Period)
+	}
+
 	var firstRun = true
 	var currentTime time.Time
 	var token string
@@ -25,6 +31,7 @@ func TOTPCopier(secret string, errorChan chan<- error, done <-chan bool) {
 		token, err = core.GenTOTP(secret, currentTime)
 		if firstRun && err != nil {
 			errorChan <- err
+			return // e.g. invalid or counter-based (HOTP) secret; no token can be generated
 		}
 		err = CopyBytes(false, []byte(token))
 		if firstRun {
@@ -36,8 +43,8 @@ func TOTPCopier(secret string, errorChan chan<- error, done <-chan bool) {
 			firstRun = false
 		}
 
-		// sleep till next 30-second interval
-		time.Sleep(time.Duration(30-(currentTime.Second()%30)) * time.Second)
+		// sleep till next interval
+		time.Sleep(time.Duration(period-(currentTime.Unix()%period)) * time.Second)
 
 		// exit after sleep if indicated (will not update clipboard again)
 		select {
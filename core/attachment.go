@@ -0,0 +1,61 @@
+package core
+
+import (
+	"errors"
+	"os"
+	"strings"
+
+	"github.com/rwinkhart/go-boilerplate/back"
+	"github.com/rwinkhart/libmutton/crypt"
+	"github.com/rwinkhart/libmutton/global"
+	"github.com/rwinkhart/libmutton/syncclient"
+	"github.com/rwinkhart/libmutton/synccommon"
+)
+
+// AddAttachment encrypts decBytes and stores it as an attachment
+// (identified by name) of the entry at vanityPath.
+// Existing attachments with the same name are replaced.
+// Attachments are stored next to their entry (see global.GetAttachmentVanityPath),
+// so they are synchronized, renamed, and sheared along with it.
+// decBytes is zeroized after encryption.
+// Leave rcwPassword nil to use RCW demonization.
+func AddAttachment(vanityPath, name string, decBytes, rcwPassword []byte) error {
+	if name == "" || strings.ContainsAny(name, "/\\\r\n") || strings.Contains(name, global.FSMisc) || strings.Contains(name, global.FSPath) || strings.Contains(name, global.FSSpace) {
+		return errors.New("attachment name \"" + name + "\" is empty or contains invalid characters")
+	}
+	if global.IsAttachment(vanityPath) {
+		return errors.New("unable to attach to \"" + vanityPath + "\": target is an attachment")
+	}
+	// ensure the entry exists and is a file
+	if _, err := back.TargetIsFile(global.GetRealPath(vanityPath), true); err != nil {
+		return err
+	}
+
+	if err := os.WriteFile(global.GetRealPath(global.GetAttachmentVanityPath(vanityPath, name)), crypt.EncryptBytes(decBytes, rcwPassword), 0600); err != nil {
+		return errors.New("unable to write attachment: " + err.Error())
+	}
+	return nil
+}
+
+// GetAttachmentList returns the names of all attachments of the entry at vanityPath.
+func GetAttachmentList(vanityPath string) ([]string, error) {
+	return synccommon.GetAttachmentList(vanityPath)
+}
+
+// GetAttachment decrypts and returns the attachment
+// (identified by name) of the entry at vanityPath.
+// Leave rcwPassword nil to use RCW demonization.
+func GetAttachment(vanityPath, name string, rcwPassword []byte) ([]byte, error) {
+	decBytes, err := crypt.DecryptFileToBytes(global.GetRealPath(global.GetAttachmentVanityPath(vanityPath, name)), rcwPassword)
+	if err != nil {
+		return nil, errors.New("unable to decrypt attachment: " + err.Error())
+	}
+	return decBytes, nil
+}
+
+// RemoveAttachment shears the attachment (identified by name)
+// of the entry at vanityPath locally and remotely.
+// Unlike syncclient.ShearRemote, it does not exit the program.
+func RemoveAttachment(vanityPath, name string) error {
+	return syncclient.ShearTargetsRemote([]string{global.GetAttachmentVanityPath(vanityPath, name)}, false)
+}
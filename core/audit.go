@@ -0,0 +1,134 @@
+package core
+
+import (
+	"bytes"
+	"cmp"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"slices"
+
+	"github.com/rwinkhart/go-boilerplate/security"
+	"github.com/rwinkhart/libmutton/crypt"
+	"github.com/rwinkhart/libmutton/global"
+	"github.com/rwinkhart/libmutton/synccommon"
+)
+
+// WeakPasswordT describes an entry whose password failed a strength audit.
+type WeakPasswordT struct {
+	VanityPath string
+	Strength   StrengthT
+}
+
+// AuditWeakPasswords decrypts all entries and returns those whose
+// password (field 0) has a strength score (see EstimateStrength) below minScore.
+// Entries without passwords are skipped.
+// Leave rcwPassword nil to use RCW demonization.
+func AuditWeakPasswords(minScore uint8, rcwPassword []byte) ([]WeakPasswordT, error) {
+	entries, _, err := synccommon.WalkEntryDir()
+	if err != nil {
+		return nil, errors.New("unable to walk entry directory: " + err.Error())
+	}
+	var weakPasswords []WeakPasswordT
+	for _, vanityPath := range entries {
+		if global.IsAttachment(vanityPath) {
+			continue
+		}
+		// clone rcwPassword for each decryption, as it is zeroized after use (nil is preserved for RCW demonization)
+		decSlice, err := crypt.DecryptFileToSlice(global.GetRealPath(vanityPath), bytes.Clone(rcwPassword))
+		if err != nil {
+			return nil, errors.New("unable to decrypt \"" + vanityPath + "\": " + err.Error())
+		}
+		if decSlice[global.FieldPassword] == "" {
+			continue
+		}
+		if strength := EstimateStrength(decSlice[global.FieldPassword]); strength.Score < minScore {
+			weakPasswords = append(weakPasswords, WeakPasswordT{VanityPath: vanityPath, Strength: strength})
+		}
+	}
+	return weakPasswords, nil
+}
+
+// ReuseGroupT is a group of entries that share a password.
+type ReuseGroupT struct {
+	VanityPaths []string // sorted
+	Exact       bool     // false if the passwords are only near-identical (same base, different numeric suffix)
+}
+
+// FindReusedPasswords decrypts all entries and returns groups of
+// entries sharing an identical password. If includeSimilar is true,
+// groups of near-identical passwords (same base with a different
+// numeric suffix, e.g. "hunter1"/"hunter2") are returned as well.
+// Passwords are compared by HMAC (with a random, single-use key)
+// so that plaintext is zeroized as soon as each entry is hashed.
+// Leave rcwPassword nil to use RCW demonization.
+func FindReusedPasswords(includeSimilar bool, rcwPassword []byte) ([]ReuseGroupT, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, errors.New("unable to generate hash key: " + err.Error())
+	}
+	defer security.ZeroizeBytes(key)
+	keyedHash := func(data []byte) string {
+		mac := hmac.New(sha256.New, key)
+		mac.Write(data)
+		return string(mac.Sum(nil))
+	}
+
+	entries, _, err := synccommon.WalkEntryDir()
+	if err != nil {
+		return nil, errors.New("unable to walk entry directory: " + err.Error())
+	}
+	exactMap := make(map[string][]string)   // password hash -> vanity paths
+	similarMap := make(map[string][]string) // base hash -> password hashes (one per vanity path)
+	baseVanityPaths := make(map[string][]string)
+	for _, vanityPath := range entries {
+		if global.IsAttachment(vanityPath) {
+			continue
+		}
+		// clone rcwPassword for each decryption, as it is zeroized after use (nil is preserved for RCW demonization)
+		decBytes, err := crypt.DecryptFileToBytes(global.GetRealPath(vanityPath), bytes.Clone(rcwPassword))
+		if err != nil {
+			return nil, errors.New("unable to decrypt \"" + vanityPath + "\": " + err.Error())
+		}
+		password, _, _ := bytes.Cut(decBytes, []byte("\n"))
+		if len(password) == 0 {
+			security.ZeroizeBytes(decBytes)
+			continue
+		}
+		passwordHash := keyedHash(password)
+		exactMap[passwordHash] = append(exactMap[passwordHash], vanityPath)
+		if includeSimilar {
+			// strip the numeric suffix; require a meaningful base to avoid false positives
+			base := bytes.TrimRight(password, "0123456789")
+			if len(base) >= 4 {
+				baseHash := keyedHash(base)
+				similarMap[baseHash] = append(similarMap[baseHash], passwordHash)
+				baseVanityPaths[baseHash] = append(baseVanityPaths[baseHash], vanityPath)
+			}
+		}
+		security.ZeroizeBytes(decBytes)
+	}
+
+	// form groups
+	var groups []ReuseGroupT
+	for _, vanityPaths := range exactMap {
+		if len(vanityPaths) > 1 {
+			slices.Sort(vanityPaths)
+			groups = append(groups, ReuseGroupT{VanityPaths: vanityPaths, Exact: true})
+		}
+	}
+	for baseHash, passwordHashes := range similarMap {
+		// only report bases shared by multiple distinct passwords (identical ones are already reported)
+		slices.Sort(passwordHashes)
+		if len(slices.Compact(passwordHashes)) > 1 {
+			vanityPaths := baseVanityPaths[baseHash]
+			slices.Sort(vanityPaths)
+			groups = append(groups, ReuseGroupT{VanityPaths: vanityPaths})
+		}
+	}
+	slices.SortFunc(groups, func(a, b ReuseGroupT) int {
+		return cmp.Compare(a.VanityPaths[0], b.VanityPaths[0])
+	})
+	return groups, nil
+}
@@ -0,0 +1,181 @@
+package core
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/rwinkhart/go-boilerplate/back"
+	"github.com/rwinkhart/libmutton/crypt"
+	"github.com/rwinkhart/libmutton/global"
+)
+
+// EntryT is the typed representation of a decrypted libmutton entry.
+// See global.Field* for the line index each field is stored at.
+type EntryT struct {
+	Password string
+	Username string
+	TOTP     string
+	URL      string
+	Notes    []string // one string per line
+	Custom   []CustomFieldT
+}
+
+// CustomFieldT is a named, single-line field stored alongside the standard entry fields.
+type CustomFieldT struct {
+	Name   string
+	Value  string
+	Hidden bool // hidden fields should be masked by clients (e.g. PINs, security answers)
+}
+
+// Custom fields are stored as trailing note lines so that clients without
+// custom field support still display them (as notes) and preserve them.
+// Format: FSMisc + kind + FSSpace + name + FSSpace + value
+const (
+	customFieldVisible = "v"
+	customFieldHidden  = "h"
+)
+
+// ParseEntry converts a decrypted entry (as returned by
+// crypt.DecryptFileToSlice) into an EntryT.
+// Missing lines are treated as empty fields.
+func ParseEntry(decSlice []string) *EntryT {
+	decSlice = ensureSliceLength(decSlice, global.FieldURL)
+	entry := &EntryT{
+		Password: decSlice[global.FieldPassword],
+		Username: decSlice[global.FieldUsername],
+		TOTP:     decSlice[global.FieldTOTP],
+		URL:      decSlice[global.FieldURL],
+	}
+	if len(decSlice) > global.FieldNotes {
+		notes := decSlice[global.FieldNotes:]
+		// custom fields are only recognized in the trailing block of note lines
+		firstCustom := len(notes)
+		for firstCustom > 0 {
+			if _, ok := parseCustomField(notes[firstCustom-1]); !ok {
+				break
+			}
+			firstCustom--
+		}
+		if firstCustom > 0 {
+			entry.Notes = append([]string(nil), notes[:firstCustom]...)
+		}
+		for _, line := range notes[firstCustom:] {
+			customField, _ := parseCustomField(line)
+			entry.Custom = append(entry.Custom, customField)
+		}
+	}
+	return entry
+}
+
+// SerializeEntry converts an EntryT back into the line format used on disk.
+// Trailing empty lines are omitted, so the output of ParseEntry
+// round-trips exactly for any entry written by WriteEntry.
+func SerializeEntry(entry *EntryT) []string {
+	if entry == nil {
+		return nil
+	}
+	decSlice := make([]string, global.FieldNotes, global.FieldNotes+len(entry.Notes)+len(entry.Custom))
+	decSlice[global.FieldPassword] = entry.Password
+	decSlice[global.FieldUsername] = entry.Username
+	decSlice[global.FieldTOTP] = entry.TOTP
+	decSlice[global.FieldURL] = entry.URL
+	decSlice = append(decSlice, entry.Notes...)
+	for _, customField := range entry.Custom {
+		kind := customFieldVisible
+		if customField.Hidden {
+			kind = customFieldHidden
+		}
+		decSlice = append(decSlice, global.FSMisc+kind+global.FSSpace+customField.Name+global.FSSpace+customField.Value)
+	}
+	return back.RemoveTrailingEmptyStrings(decSlice)
+}
+
+// parseCustomField parses a single note line as a custom field.
+// Returns: the custom field, whether the line was a valid custom field.
+func parseCustomField(line string) (CustomFieldT, bool) {
+	if !strings.HasPrefix(line, global.FSMisc) {
+		return CustomFieldT{}, false
+	}
+	split := strings.SplitN(line[len(global.FSMisc):], global.FSSpace, 3)
+	if len(split) != 3 || split[1] == "" {
+		return CustomFieldT{}, false
+	}
+	switch split[0] {
+	case customFieldVisible:
+		return CustomFieldT{Name: split[1], Value: split[2]}, true
+	case customFieldHidden:
+		return CustomFieldT{Name: split[1], Value: split[2], Hidden: true}, true
+	}
+	return CustomFieldT{}, false
+}
+
+// GetCustomField returns the custom field in entry with the given name,
+// or nil if no such field exists.
+func GetCustomField(entry *EntryT, name string) *CustomFieldT {
+	for i := range entry.Custom {
+		if entry.Custom[i].Name == name {
+			return &entry.Custom[i]
+		}
+	}
+	return nil
+}
+
+// SetCustomField adds a custom field to entry or, if a field
+// with the same name already exists, replaces its value and visibility.
+// Names must be non-empty; neither names nor values may span multiple lines.
+func SetCustomField(entry *EntryT, name, value string, hidden bool) error {
+	if name == "" {
+		return errors.New("custom field name cannot be empty")
+	}
+	if strings.ContainsAny(name, "\r\n") || strings.Contains(name, global.FSSpace) {
+		return errors.New("custom field name \"" + name + "\" contains invalid characters")
+	}
+	if strings.ContainsAny(value, "\r\n") {
+		return errors.New("value for custom field \"" + name + "\" cannot span multiple lines")
+	}
+	if customField := GetCustomField(entry, name); customField != nil {
+		customField.Value = value
+		customField.Hidden = hidden
+		return nil
+	}
+	entry.Custom = append(entry.Custom, CustomFieldT{Name: name, Value: value, Hidden: hidden})
+	return nil
+}
+
+// RemoveCustomField removes the custom field with the given name from entry.
+// Returns: whether a field was removed.
+func RemoveCustomField(entry *EntryT, name string) bool {
+	for i := range entry.Custom {
+		if entry.Custom[i].Name == name {
+			entry.Custom = append(entry.Custom[:i], entry.Custom[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// GetOldParsedEntry decrypts and returns old entry data as an EntryT.
+// It is the typed equivalent of GetOldEntryData.
+// Leave rcwPassword nil to use RCW demonization.
+func GetOldParsedEntry(realPath string, rcwPassword []byte) (*EntryT, error) {
+	// ensure realPath exists and is a file
+	_, err := back.TargetIsFile(realPath, true)
+	if err != nil {
+		return nil, err
+	}
+
+	// read old entry data
+	decSlice, err := crypt.DecryptFileToSlice(realPath, rcwPassword)
+	if err != nil {
+		return nil, errors.New("unable to decrypt entry: " + err.Error())
+	}
+
+	return ParseEntry(decSlice), nil
+}
+
+// WriteParsedEntry writes entry to an encrypted file at realPath.
+// It is the typed equivalent of WriteEntry.
+// Leave rcwPassword nil to use RCW demonization.
+func WriteParsedEntry(realPath string, entry *EntryT, passwordIsNew bool, rcwPassword []byte) error {
+	return WriteEntry(realPath, SerializeEntry(entry), passwordIsNew, rcwPassword)
+}
@@ -0,0 +1,173 @@
+package core
+
+import (
+	"bytes"
+	"crypto/rand"
+	_ "embed"
+	"errors"
+	"math/big"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/rwinkhart/libmutton/global"
+)
+
+// wordlist is the EFF large wordlist (https://www.eff.org/dice),
+// used under the CC BY 3.0 US license.
+// Format: one "<dice roll>\t<word>" pair per line.
+//
+//go:embed wordlist.txt
+var wordlist string
+
+var getWords = sync.OnceValue(func() []string {
+	lines := strings.Split(strings.TrimSpace(wordlist), "\n")
+	words := make([]string, len(lines))
+	for i, line := range lines {
+		words[i] = strings.TrimSpace(line[strings.IndexByte(line, '\t')+1:])
+	}
+	return words
+})
+
+// Character classes used for password generation
+const (
+	genLower     = "abcdefghijklmnopqrstuvwxyz"
+	genUpper     = "ABCDEFGHIJKLMNOPQRSTUVWXYZ"
+	genDigits    = "0123456789"
+	genSymbols   = "!#$%&()*+,-.:;<=>?@[]^_{}~"
+	genAmbiguous = "Il1O0o"
+)
+
+// GenPolicyT defines the requirements for a generated password or passphrase.
+// A character class is used if it is enabled or if its minimum count is above 0.
+type GenPolicyT struct {
+	Length           int  // number of characters (ignored in passphrase mode)
+	Lower            bool // use lowercase letters
+	Upper            bool // use uppercase letters
+	Digits           bool // use digits
+	Symbols          bool // use symbols
+	MinLower         int
+	MinUpper         int
+	MinDigits        int
+	MinSymbols       int
+	ExcludeAmbiguous bool // exclude easily confused characters (e.g. "l", "1", "O", "0")
+
+	Passphrase bool   // generate a diceware-style passphrase instead of a password
+	Words      int    // number of words (passphrase mode only)
+	Separator  string // placed between words (passphrase mode only)
+}
+
+// DefaultGenPolicy returns the recommended password generation policy.
+func DefaultGenPolicy() *GenPolicyT {
+	return &GenPolicyT{Length: 20, MinLower: 1, MinUpper: 1, MinDigits: 1, MinSymbols: 1}
+}
+
+// GenPassword generates a password (or passphrase) satisfying policy.
+func GenPassword(policy *GenPolicyT) (string, error) {
+	if policy.Passphrase {
+		return genPassphrase(policy)
+	}
+	if policy.Length < 1 {
+		return "", errors.New("password length must be at least 1")
+	}
+
+	// determine character classes
+	classes := []struct {
+		charset  string
+		enabled  bool
+		minCount int
+	}{
+		{genLower, policy.Lower, policy.MinLower},
+		{genUpper, policy.Upper, policy.MinUpper},
+		{genDigits, policy.Digits, policy.MinDigits},
+		{genSymbols, policy.Symbols, policy.MinSymbols},
+	}
+	var fullCharset string
+	var minTotal int
+	result := make([]byte, 0, policy.Length)
+	for _, class := range classes {
+		if !class.enabled && class.minCount < 1 {
+			continue
+		}
+		charset := class.charset
+		if policy.ExcludeAmbiguous {
+			charset = strings.Map(func(r rune) rune {
+				if strings.ContainsRune(genAmbiguous, r) {
+					return -1
+				}
+				return r
+			}, charset)
+		}
+		fullCharset += charset
+		minTotal += max(class.minCount, 0)
+		// satisfy the minimum count for the class up front
+		for range class.minCount {
+			result = append(result, charset[randIndex(len(charset))])
+		}
+	}
+	if fullCharset == "" {
+		return "", errors.New("no character classes selected")
+	}
+	if minTotal > policy.Length {
+		return "", errors.New("sum of minimum character counts exceeds password length")
+	}
+
+	// fill the remaining length from all enabled classes
+	for len(result) < policy.Length {
+		result = append(result, fullCharset[randIndex(len(fullCharset))])
+	}
+
+	// shuffle to avoid predictable class positions (Fisher-Yates)
+	for i := len(result) - 1; i > 0; i-- {
+		j := randIndex(i + 1)
+		result[i], result[j] = result[j], result[i]
+	}
+	return string(result), nil
+}
+
+// genPassphrase generates a diceware-style passphrase satisfying policy.
+func genPassphrase(policy *GenPolicyT) (string, error) {
+	if policy.Words < 1 {
+		return "", errors.New("passphrase must contain at least one word")
+	}
+	words := getWords()
+	chosen := make([]string, policy.Words)
+	for i := range chosen {
+		chosen[i] = words[randIndex(len(words))]
+	}
+	return strings.Join(chosen, policy.Separator), nil
+}
+
+// randIndex returns a cryptographically secure random integer in [0, n).
+func randIndex(n int) int {
+	val, _ := rand.Int(rand.Reader, big.NewInt(int64(n)))
+	return int(val.Int64())
+}
+
+// GenEntryPassword generates a password (or passphrase) satisfying policy
+// and writes it to the entry at realPath (created if it does not exist).
+// Since it is written through WriteEntry, the entry's age data is updated as well.
+// Returns: the generated password.
+// Leave rcwPassword nil to use RCW demonization.
+func GenEntryPassword(realPath string, policy *GenPolicyT, rcwPassword []byte) (string, error) {
+	password, err := GenPassword(policy)
+	if err != nil {
+		return "", err
+	}
+
+	// load existing entry data (if the entry exists)
+	decSlice := []string{""}
+	if _, err = os.Stat(realPath); err == nil {
+		// clone rcwPassword, as it is zeroized after use (nil is preserved for RCW demonization)
+		decSlice, err = GetOldEntryData(realPath, global.FieldPassword, bytes.Clone(rcwPassword))
+		if err != nil {
+			return "", err
+		}
+	}
+
+	decSlice[global.FieldPassword] = password
+	if err = WriteEntry(realPath, decSlice, true, rcwPassword); err != nil {
+		return "", err
+	}
+	return password, nil
+}
@@ -0,0 +1,28 @@
+package core
+
+import (
+	"bytes"
+	"errors"
+
+	"github.com/rwinkhart/libmutton/global"
+	"github.com/rwinkhart/libmutton/history"
+)
+
+// RestoreRevision replaces the entry at vanityPath with the revision
+// identified by timestamp (UNIX nanoseconds; see history.List).
+// The replaced version is itself kept in the revision history,
+// so a restore can always be undone.
+// Leave rcwPassword nil to use RCW demonization.
+func RestoreRevision(vanityPath string, timestamp int64, rcwPassword []byte) error {
+	// clone rcwPassword for each use, as it is zeroized after each operation (nil is preserved for RCW demonization)
+	revSlice, err := history.Decrypt(vanityPath, timestamp, bytes.Clone(rcwPassword))
+	if err != nil {
+		return err
+	}
+	curSlice, err := GetOldEntryData(global.GetRealPath(vanityPath), global.FieldPassword, bytes.Clone(rcwPassword))
+	if err != nil {
+		return errors.New("unable to read current version of " + vanityPath + ": " + err.Error())
+	}
+	revSlice = ensureSliceLength(revSlice, global.FieldPassword)
+	return WriteEntry(global.GetRealPath(vanityPath), revSlice, revSlice[global.FieldPassword] != curSlice[global.FieldPassword], rcwPassword)
+}
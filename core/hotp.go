@@ -0,0 +1,76 @@
+package core
+
+import (
+	"bytes"
+	"errors"
+	"net/url"
+	"strconv"
+
+	"github.com/pquerna/otp/hotp"
+	"github.com/rwinkhart/libmutton/global"
+)
+
+// GenHOTP generates an HOTP token for the entry at realPath (whose
+// TOTP field must contain an "otpauth://hotp/" URI) and persists the
+// incremented counter back into the entry, so that it syncs like any other change
+// (counter increments are not kept in the revision history).
+// Leave rcwPassword nil to use RCW demonization.
+func GenHOTP(realPath string, rcwPassword []byte) (string, error) {
+	// clone rcwPassword, as it is zeroized after use (nil is preserved for RCW demonization)
+	decSlice, err := GetOldEntryData(realPath, global.FieldTOTP, bytes.Clone(rcwPassword))
+	if err != nil {
+		return "", err
+	}
+	params, err := ParseTOTP(decSlice[global.FieldTOTP])
+	if err != nil {
+		return "", errors.New("unable to generate HOTP token: " + err.Error())
+	}
+	if !params.IsHOTP {
+		return "", errors.New("unable to generate HOTP token: secret is not counter-based")
+	}
+
+	hotpToken, err := hotp.GenerateCodeCustom(params.Secret, params.Counter, hotp.ValidateOpts{Digits: params.Digits, Algorithm: params.Algorithm, Encoder: params.Encoder})
+	if err != nil {
+		return "", errors.New("unable to generate HOTP token: " + err.Error())
+	}
+
+	// persist the incremented counter (without recording a revision, as each use would otherwise evict a real one)
+	decSlice[global.FieldTOTP], err = setHOTPCounter(decSlice[global.FieldTOTP], params.Counter+1)
+	if err != nil {
+		return "", err
+	}
+	if err = writeEntry(realPath, decSlice, false, false, rcwPassword); err != nil {
+		return "", errors.New("unable to save HOTP counter: " + err.Error())
+	}
+
+	return hotpToken, nil
+}
+
+// getHOTPCounter returns the counter parameter of an "otpauth://hotp/" URI (0 if not present).
+func getHOTPCounter(uri string) (uint64, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return 0, errors.New("unable to parse otpauth URI: " + err.Error())
+	}
+	counterString := u.Query().Get("counter")
+	if counterString == "" {
+		return 0, nil
+	}
+	counter, err := strconv.ParseUint(counterString, 10, 64)
+	if err != nil {
+		return 0, errors.New("otpauth URI contains an invalid counter")
+	}
+	return counter, nil
+}
+
+// setHOTPCounter returns uri with its counter parameter set to counter.
+func setHOTPCounter(uri string, counter uint64) (string, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return "", errors.New("unable to parse otpauth URI: " + err.Error())
+	}
+	query := u.Query()
+	query.Set("counter", strconv.FormatUint(counter, 10))
+	u.RawQuery = query.Encode()
+	return u.String(), nil
+}
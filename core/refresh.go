@@ -0,0 +1,234 @@
+package core
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/rwinkhart/go-boilerplate/security"
+	"github.com/rwinkhart/libmutton/crypt"
+	"github.com/rwinkhart/libmutton/global"
+	"github.com/rwinkhart/libmutton/history"
+	"github.com/rwinkhart/libmutton/synccommon"
+	"github.com/rwinkhart/rcw/wrappers"
+)
+
+// getSanityPath returns the path to the RCW sanity check file.
+func getSanityPath() string {
+	return global.CfgDir + global.PathSeparator + "sanity.rcw"
+}
+
+// EntryRefresh re-encrypts all libmutton entries (and their revision histories)
+// with a new password and optimizes each entry to ensure they are as slim as possible.
+// Entries can be re-encrypted concurrently (see crypt.ReencryptWorkers).
+// The refresh is journaled (see global.RecoverEntryRefresh); if it is interrupted while swapping in
+// the re-encrypted entries, it is completed automatically. If it is interrupted (or fails) while re-encrypting,
+// the original entries are left untouched and the next call to EntryRefresh with the same newRCWPassword
+// resumes it (skipping files that were already re-encrypted and have not changed since);
+// calling it with a different newRCWPassword discards the interrupted refresh and starts over.
+// The original entries and revision histories are kept in global.EntryRoot+"-old" and global.HistDir+"-old".
+// The key generation is incremented so that other devices adopt the new password on their next sync.
+// newRCWPassword is zeroized once it is no longer needed.
+func EntryRefresh(oldRCWPassword, newRCWPassword []byte, removeOldDir bool) error {
+	defer security.ZeroizeBytes(newRCWPassword)
+
+	// complete any refresh that was previously interrupted while swapping
+	if _, err := global.RecoverEntryRefresh(); err != nil {
+		return err
+	}
+
+	// ensure global.EntryRoot+"-old" does not exist (same for global.HistDir)
+	for _, dirRoot := range []string{global.EntryRoot, global.HistDir} {
+		if !removeOldDir {
+			if _, err := os.Stat(dirRoot + "-old"); !os.IsNotExist(err) {
+				return errors.New("unable to refresh entries: \"" + dirRoot + "-old\" already exists")
+			}
+		}
+		if err := os.RemoveAll(dirRoot + "-old"); err != nil {
+			return errors.New("unable to remove \"" + dirRoot + "-old\": " + err.Error())
+		}
+	}
+
+	// resume a refresh that was previously interrupted while staging (if it was started with the same new password);
+	// otherwise, discard its staged output and begin a new staging phase
+	phase, err := global.GetRefreshPhase()
+	if err != nil {
+		return err
+	}
+	if phase != global.RefreshPhaseStage || wrappers.RunSanityCheck(getSanityPath()+"-new", newRCWPassword) != nil {
+		if err = global.RollBackEntryRefresh(); err != nil {
+			return err
+		}
+		keyGeneration, err := synccommon.GetKeyGeneration()
+		if err != nil {
+			return err
+		}
+		if err = global.WriteRefreshJournal(global.RefreshPhaseStage); err != nil {
+			return err
+		}
+		if err = synccommon.StageKeyGeneration(keyGeneration + 1); err != nil {
+			return err
+		}
+		// the new sanity check file is generated first so that resumption can verify the new password
+		if err = wrappers.GenSanityCheck(getSanityPath()+"-new", newRCWPassword, false); err != nil {
+			return errors.New("unable to generate sanity check file: " + err.Error())
+		}
+	}
+	if err = stageEntryRefresh(oldRCWPassword, newRCWPassword); err != nil {
+		return err
+	}
+
+	// begin swap phase (from here on, the refresh is always completed)
+	if err = global.WriteRefreshJournal(global.RefreshPhaseSwap); err != nil {
+		return err
+	}
+	return global.RollForwardEntryRefresh()
+}
+
+// stageEntryRefresh writes re-encrypted copies of all entries and revision histories alongside the originals.
+// Completed files are recorded in the refresh progress file (see global.GetRefreshProgressPath)
+// and skipped if staging is resumed (unless their source has changed since).
+func stageEntryRefresh(oldRCWPassword, newRCWPassword []byte) error {
+	// create output directory structure (global.EntryRoot + "-new"/*)
+	entries, folders, err := synccommon.WalkEntryDir()
+	if err != nil {
+		return errors.New("unable to walk entry directory: " + err.Error())
+	}
+	var dstDirs []string
+	for _, folder := range folders {
+		fullPath := global.EntryRoot + "-new" + strings.ReplaceAll(folder, "/", global.PathSeparator)
+		if err = os.MkdirAll(fullPath, 0700); err != nil {
+			return errors.New("unable to create temporary directory \"" + fullPath + "\": " + err.Error())
+		}
+		dstDirs = append(dstDirs, fullPath)
+	}
+
+	// decrypt, optimize, and re-encrypt each entry
+	jobs := make([]crypt.ReencryptJobT, len(entries))
+	for i, vanityPath := range entries {
+		jobs[i] = crypt.ReencryptJobT{
+			SrcPath: global.GetRealPath(vanityPath),
+			DstPath: global.EntryRoot + "-new" + strings.ReplaceAll(vanityPath, "/", global.PathSeparator),
+		}
+		// split & optimize entry (attachments are binary and are re-encrypted as-is)
+		if !global.IsAttachment(vanityPath) {
+			jobs[i].Transform = func(decBytes []byte) []byte {
+				return []byte(strings.Join(clampTrailingWhitespace(strings.Split(string(decBytes), "\n")), "\n"))
+			}
+		}
+	}
+
+	// re-encrypt the revision history
+	histJobs, histDirs, err := history.GetReencryptJobs()
+	if err != nil {
+		return errors.New("unable to refresh revision history: " + err.Error())
+	}
+	jobs = append(jobs, histJobs...)
+	dstDirs = append(dstDirs, histDirs...)
+
+	// remove staged output that no longer has a source (e.g. entries removed by a sync since staging was interrupted)
+	if err = pruneStagedRefresh(jobs, dstDirs); err != nil {
+		return err
+	}
+
+	// skip files completed by an interrupted previous attempt and record the progress of the rest
+	progress, err := loadRefreshProgress()
+	if err != nil {
+		return err
+	}
+	progressFile, err := os.OpenFile(global.GetRefreshProgressPath(), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return errors.New("unable to open refresh progress file: " + err.Error())
+	}
+	defer func(progressFile *os.File) {
+		_ = progressFile.Close() // error ignored; each record is synced as it is written
+	}(progressFile)
+	var progressMutex sync.Mutex
+	var pendingJobs []crypt.ReencryptJobT
+	for _, job := range jobs {
+		srcInfo, err := os.Stat(job.SrcPath)
+		if err != nil {
+			return errors.New("unable to get file info for \"" + job.SrcPath + "\": " + err.Error())
+		}
+		fingerprint := strconv.FormatInt(srcInfo.ModTime().UnixNano(), 10) + "-" + strconv.FormatInt(srcInfo.Size(), 10)
+		if progress[job.DstPath] == fingerprint {
+			if _, err = os.Stat(job.DstPath); err == nil {
+				continue
+			}
+		}
+		job.Done = func() error {
+			progressMutex.Lock()
+			defer progressMutex.Unlock()
+			_, err := progressFile.WriteString(fingerprint + "\t" + job.DstPath + "\n")
+			if err == nil {
+				err = progressFile.Sync()
+			}
+			if err != nil {
+				return errors.New("unable to record refresh progress: " + err.Error())
+			}
+			return nil
+		}
+		pendingJobs = append(pendingJobs, job)
+	}
+	return crypt.Reencrypt(pendingJobs, oldRCWPassword, newRCWPassword)
+}
+
+// loadRefreshProgress returns the files completed by previous staging attempts
+// (mapped from destination path to a fingerprint of the source file at the time).
+func loadRefreshProgress() (map[string]string, error) {
+	progress := make(map[string]string)
+	progressBytes, err := os.ReadFile(global.GetRefreshProgressPath())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return progress, nil
+		}
+		return nil, errors.New("unable to read refresh progress file: " + err.Error())
+	}
+	for _, record := range strings.Split(string(progressBytes), "\n") {
+		// an incomplete (last) record never matches a destination path, so it is harmlessly ignored
+		if fingerprint, dstPath, found := strings.Cut(record, "\t"); found {
+			progress[dstPath] = fingerprint
+		}
+	}
+	return progress, nil
+}
+
+// pruneStagedRefresh removes files and directories from the staged output of an EntryRefresh
+// that are not produced by any of jobs or listed in dstDirs (so that they are not swapped in).
+func pruneStagedRefresh(jobs []crypt.ReencryptJobT, dstDirs []string) error {
+	keep := make(map[string]bool, len(jobs)+len(dstDirs))
+	for _, job := range jobs {
+		keep[filepath.Clean(job.DstPath)] = true
+	}
+	for _, dstDir := range dstDirs {
+		keep[filepath.Clean(dstDir)] = true
+	}
+	for _, stagedRoot := range []string{global.EntryRoot + "-new", global.HistDir + "-new"} {
+		err := filepath.WalkDir(stagedRoot, func(realPath string, entry fs.DirEntry, err error) error {
+			if err != nil {
+				if os.IsNotExist(err) {
+					return nil
+				}
+				return err
+			}
+			if realPath == stagedRoot || keep[realPath] {
+				return nil
+			}
+			if err = os.RemoveAll(realPath); err != nil {
+				return err
+			}
+			if entry.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		})
+		if err != nil {
+			return errors.New("unable to prune staged entry refresh: " + err.Error())
+		}
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+package core
+
+import (
+	"bytes"
+	"errors"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/rwinkhart/libmutton/crypt"
+	"github.com/rwinkhart/libmutton/global"
+	"github.com/rwinkhart/libmutton/synccommon"
+)
+
+// SearchResultT describes an entry matched by Search.
+type SearchResultT struct {
+	VanityPath string
+	Fields     []int // line indices of all matching fields (see global.Field*; notes report their actual line index)
+}
+
+// Search decrypts all entries and matches query against the requested fields.
+// fields contains line indices as defined by global.Field*; global.FieldNotes
+// selects every note line. Leave fields nil to search all fields.
+// If useRegex is false, query is matched as a case-insensitive substring;
+// otherwise, it is compiled as a regular expression (use "(?i)" for case-insensitivity).
+// Leave rcwPassword nil to use RCW demonization.
+func Search(query string, fields []int, useRegex bool, rcwPassword []byte) ([]SearchResultT, error) {
+	// determine matching method
+	var match func(line string) bool
+	if useRegex {
+		re, err := regexp.Compile(query)
+		if err != nil {
+			return nil, errors.New("invalid search expression: " + err.Error())
+		}
+		match = re.MatchString
+	} else {
+		query = strings.ToLower(query)
+		match = func(line string) bool {
+			return strings.Contains(strings.ToLower(line), query)
+		}
+	}
+	fieldSelected := func(index int) bool {
+		return fields == nil || slices.Contains(fields, min(index, global.FieldNotes))
+	}
+
+	entries, _, err := synccommon.WalkEntryDir()
+	if err != nil {
+		return nil, errors.New("unable to walk entry directory: " + err.Error())
+	}
+	var results []SearchResultT
+	for _, vanityPath := range entries {
+		if global.IsAttachment(vanityPath) {
+			continue
+		}
+		// clone rcwPassword for each decryption, as it is zeroized after use (nil is preserved for RCW demonization)
+		decSlice, err := crypt.DecryptFileToSlice(global.GetRealPath(vanityPath), bytes.Clone(rcwPassword))
+		if err != nil {
+			return nil, errors.New("unable to decrypt \"" + vanityPath + "\": " + err.Error())
+		}
+		var matchedFields []int
+		for i, line := range decSlice {
+			if line != "" && fieldSelected(i) && match(line) {
+				matchedFields = append(matchedFields, i)
+			}
+		}
+		if matchedFields != nil {
+			results = append(results, SearchResultT{VanityPath: vanityPath, Fields: matchedFields})
+		}
+	}
+	return results, nil
+}
@@ -0,0 +1,295 @@
+package core
+
+import (
+	"math"
+	"slices"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// StrengthT describes the estimated strength of a password.
+type StrengthT struct {
+	Entropy  float64  // estimated entropy (bits) after accounting for detected patterns
+	Score    uint8    // see EstimateStrength for the magic number legend
+	Feedback []string // human-readable descriptions of detected weaknesses
+}
+
+// commonPasswords contains some of the most common leaked passwords, most common first.
+var commonPasswords = []string{
+	"123456", "password", "12345678", "qwerty", "123456789", "12345", "1234", "111111", "1234567", "dragon",
+	"123123", "baseball", "abc123", "football", "monkey", "letmein", "696969", "shadow", "master", "666666",
+	"qwertyuiop", "123321", "mustang", "1234567890", "michael", "654321", "superman", "1qaz2wsx", "7777777", "121212",
+	"000000", "qazwsx", "123qwe", "killer", "trustno1", "jordan", "jennifer", "zxcvbnm", "asdfgh", "hunter",
+	"buster", "soccer", "harley", "batman", "andrew", "tigger", "sunshine", "iloveyou", "2000", "charlie",
+	"robert", "thomas", "hockey", "ranger", "daniel", "starwars", "klaster", "112233", "george", "computer",
+	"michelle", "jessica", "pepper", "1111", "zxcvbn", "555555", "11111111", "131313", "freedom", "777777",
+	"pass", "maggie", "159753", "aaaaaa", "ginger", "princess", "joshua", "cheese", "amanda", "summer",
+	"love", "ashley", "nicole", "chelsea", "biteme", "matthew", "access", "yankees", "987654321", "dallas",
+	"austin", "thunder", "taylor", "matrix", "admin", "welcome", "login", "passw0rd", "secret", "qwerty123",
+}
+
+// keyboardRows contains adjacent-key sequences for common keyboard patterns.
+var keyboardRows = []string{
+	"`1234567890-=", "qwertyuiop[]\\", "asdfghjkl;'", "zxcvbnm,./",
+	"1qaz", "2wsx", "3edc", "4rfv", "5tgb", "6yhn", "7ujm", "8ik,", "9ol.", "0p;/",
+}
+
+// leetSubstitutions maps common character substitutions back to letters.
+var leetSubstitutions = map[rune]rune{'4': 'a', '@': 'a', '8': 'b', '3': 'e', '6': 'g', '1': 'i', '!': 'i', '0': 'o', '5': 's', '$': 's', '7': 't', '+': 't', '2': 'z'}
+
+// getDictionary returns a map of known words/passwords to their rank (lower is more common).
+var getDictionary = sync.OnceValue(func() map[string]int {
+	dictionary := make(map[string]int)
+	for i, password := range commonPasswords {
+		dictionary[password] = i + 1
+	}
+	for i, word := range getWords() {
+		if _, exists := dictionary[word]; !exists {
+			dictionary[word] = len(commonPasswords) + i + 1
+		}
+	}
+	return dictionary
+})
+
+// strengthMaxRunes is the number of leading runes analyzed by EstimateStrength.
+// Pattern detection scales poorly with length, and longer passwords are rated very strong regardless.
+const strengthMaxRunes = 128
+
+// strengthMatchT is a pattern detected in a password, spanning runes [start, end).
+type strengthMatchT struct {
+	start, end int
+	entropy    float64
+	feedback   string
+}
+
+// EstimateStrength estimates the strength of a password without network access.
+// Entropy is estimated by finding the cheapest combination of brute-force
+// characters and detected patterns (dictionary words, common passwords,
+// keyboard walks, sequences, and repeats) that produces the password.
+// Only the first strengthMaxRunes runes are analyzed.
+// Magic number legend (Score):
+// 0 -> very weak, 1 -> weak, 2 -> fair, 3 -> strong, 4 -> very strong
+func EstimateStrength(password string) StrengthT {
+	runes := []rune(password)
+	if len(runes) == 0 {
+		return StrengthT{Feedback: []string{"password is empty"}}
+	}
+	runes = runes[:min(len(runes), strengthMaxRunes)]
+	bruteForceEntropy := math.Log2(float64(getCardinality(runes)))
+
+	// find all pattern matches
+	var matches []strengthMatchT
+	matches = append(matches, getDictionaryMatches(runes)...)
+	matches = append(matches, getSequenceMatches(runes)...)
+	matches = append(matches, getRepeatMatches(runes)...)
+	matches = append(matches, getYearMatches(runes)...)
+
+	// determine the minimum entropy to produce each prefix of the password
+	minEntropy := make([]float64, len(runes)+1)
+	lastMatch := make([]*strengthMatchT, len(runes)+1)
+	for end := 1; end <= len(runes); end++ {
+		minEntropy[end] = minEntropy[end-1] + bruteForceEntropy
+		for i := range matches {
+			if matches[i].end == end && minEntropy[matches[i].start]+matches[i].entropy < minEntropy[end] {
+				minEntropy[end] = minEntropy[matches[i].start] + matches[i].entropy
+				lastMatch[end] = &matches[i]
+			}
+		}
+	}
+
+	// collect feedback from the patterns used
+	var feedback []string
+	for end := len(runes); end > 0; {
+		if match := lastMatch[end]; match != nil {
+			feedback = append(feedback, match.feedback)
+			end = match.start
+		} else {
+			end--
+		}
+	}
+	slices.Reverse(feedback)
+	entropy := minEntropy[len(runes)]
+	if len(runes) < 8 {
+		feedback = append(feedback, "password is shorter than 8 characters")
+	}
+
+	var score uint8
+	switch {
+	case entropy >= 80:
+		score = 4
+	case entropy >= 60:
+		score = 3
+	case entropy >= 40:
+		score = 2
+	case entropy >= 25:
+		score = 1
+	}
+	return StrengthT{Entropy: entropy, Score: score, Feedback: feedback}
+}
+
+// getCardinality returns the size of the character set a password appears to be drawn from.
+func getCardinality(runes []rune) int {
+	var lower, upper, digit, symbol, other bool
+	for _, r := range runes {
+		switch {
+		case r >= 'a' && r <= 'z':
+			lower = true
+		case r >= 'A' && r <= 'Z':
+			upper = true
+		case r >= '0' && r <= '9':
+			digit = true
+		case r < unicode.MaxASCII:
+			symbol = true
+		default:
+			other = true
+		}
+	}
+	var cardinality int
+	for _, class := range []struct {
+		used bool
+		size int
+	}{{lower, 26}, {upper, 26}, {digit, 10}, {symbol, 33}, {other, 100}} {
+		if class.used {
+			cardinality += class.size
+		}
+	}
+	return cardinality
+}
+
+// getDictionaryMatches finds dictionary words and common passwords
+// (including capitalized and leetspeak variants) within a password.
+func getDictionaryMatches(runes []rune) []strengthMatchT {
+	dictionary := getDictionary()
+	var matches []strengthMatchT
+	for start := range runes {
+		for end := start + 3; end <= len(runes); end++ {
+			var upperCount, leetCount int
+			normalized := make([]rune, 0, end-start)
+			for _, r := range runes[start:end] {
+				if unicode.IsUpper(r) {
+					upperCount++
+				} else if sub, ok := leetSubstitutions[r]; ok {
+					leetCount++
+					normalized = append(normalized, sub)
+					continue
+				}
+				normalized = append(normalized, unicode.ToLower(r))
+			}
+			word := string(normalized)
+			rank, ok := dictionary[word]
+			if !ok {
+				// common passwords containing digits (e.g. "123456") are stored as-is
+				word = strings.ToLower(string(runes[start:end]))
+				rank, ok = dictionary[word]
+				leetCount = 0
+			}
+			if !ok {
+				continue
+			}
+			entropy := math.Log2(float64(rank))
+			if upperCount > 0 && upperCount < end-start {
+				entropy += float64(upperCount) // mixed case adds little
+			} else if upperCount > 0 {
+				entropy++ // all-uppercase
+			}
+			entropy += float64(leetCount)
+			feedback := "contains the dictionary word \"" + word + "\""
+			if rank <= len(commonPasswords) {
+				feedback = "contains the common password \"" + word + "\""
+			}
+			matches = append(matches, strengthMatchT{start: start, end: end, entropy: max(entropy, 1), feedback: feedback})
+		}
+	}
+	return matches
+}
+
+// getSequenceMatches finds keyboard walks (e.g. "qwerty", "1qaz")
+// and alphabetical/numerical sequences (e.g. "abcd", "9876") within a password.
+func getSequenceMatches(runes []rune) []strengthMatchT {
+	var matches []strengthMatchT
+	lower := []rune(strings.ToLower(string(runes)))
+	for start := range lower {
+		for end := start + 3; end <= len(lower); end++ {
+			segment := string(lower[start:end])
+			reversed := reverseString(segment)
+			var isKeyboard bool
+			for _, row := range keyboardRows {
+				if strings.Contains(row, segment) || strings.Contains(row, reversed) {
+					isKeyboard = true
+					break
+				}
+			}
+			if isKeyboard {
+				// starting key + length + direction
+				matches = append(matches, strengthMatchT{start: start, end: end, entropy: math.Log2(47) + math.Log2(float64(end-start)) + 1, feedback: "contains the keyboard pattern \"" + segment + "\""})
+				continue
+			}
+			if isSequence(lower[start:end]) {
+				// starting character + length + direction
+				matches = append(matches, strengthMatchT{start: start, end: end, entropy: math.Log2(26) + math.Log2(float64(end-start)) + 1, feedback: "contains the sequence \"" + segment + "\""})
+			}
+		}
+	}
+	return matches
+}
+
+// isSequence returns whether runes ascend or descend by exactly one at each step.
+func isSequence(runes []rune) bool {
+	delta := runes[1] - runes[0]
+	if delta != 1 && delta != -1 {
+		return false
+	}
+	for i := 2; i < len(runes); i++ {
+		if runes[i]-runes[i-1] != delta {
+			return false
+		}
+	}
+	return true
+}
+
+// getRepeatMatches finds repeated characters (e.g. "aaaa") and
+// repeated substrings (e.g. "abcabc") within a password.
+func getRepeatMatches(runes []rune) []strengthMatchT {
+	var matches []strengthMatchT
+	for start := range runes {
+		for unitLen := 1; start+unitLen*2 <= len(runes); unitLen++ {
+			unit := string(runes[start : start+unitLen])
+			end := start + unitLen
+			for end+unitLen <= len(runes) && string(runes[end:end+unitLen]) == unit {
+				end += unitLen
+				// the repeated unit is brute-forced once, then only the repeat count is guessed
+				minRepeats := 2
+				if unitLen == 1 {
+					minRepeats = 3
+				}
+				if (end-start)/unitLen >= minRepeats {
+					entropy := float64(unitLen)*math.Log2(float64(getCardinality(runes[start:start+unitLen]))) + math.Log2(float64((end-start)/unitLen))
+					matches = append(matches, strengthMatchT{start: start, end: end, entropy: entropy, feedback: "contains the repeated pattern \"" + string(runes[start:end]) + "\""})
+				}
+			}
+		}
+	}
+	return matches
+}
+
+// getYearMatches finds recent years (1900-2099) within a password.
+func getYearMatches(runes []rune) []strengthMatchT {
+	var matches []strengthMatchT
+	for start := 0; start+4 <= len(runes); start++ {
+		year := string(runes[start : start+4])
+		if (strings.HasPrefix(year, "19") || strings.HasPrefix(year, "20")) && strings.Trim(year, "0123456789") == "" {
+			matches = append(matches, strengthMatchT{start: start, end: start + 4, entropy: math.Log2(200), feedback: "contains the year \"" + year + "\""})
+		}
+	}
+	return matches
+}
+
+// reverseString returns s with its runes in reverse order.
+func reverseString(s string) string {
+	runes := []rune(s)
+	for i, j := 0, len(runes)-1; i < j; i, j = i+1, j-1 {
+		runes[i], runes[j] = runes[j], runes[i]
+	}
+	return string(runes)
+}
@@ -2,7 +2,9 @@ package core
 
 import (
 	"errors"
+	"net/url"
 	"os"
+	"strconv"
 	"strings"
 	"time"
 
@@ -12,15 +14,31 @@ import (
 	"github.com/rwinkhart/libmutton/age"
 	"github.com/rwinkhart/libmutton/crypt"
 	"github.com/rwinkhart/libmutton/global"
+	"github.com/rwinkhart/libmutton/history"
 	"github.com/rwinkhart/libmutton/syncclient"
 	"github.com/rwinkhart/libmutton/synccommon"
 	"github.com/rwinkhart/rcw/wrappers"
 )
 
 // WriteEntry writes decSlice to an encrypted file at realPath.
-// If the entry contains an updated password, an age file is also created.
+// If the entry contains an updated password, an age file is also created
+// (or, if the password was removed, the age file is sheared without exiting the program).
 // Leave rcwPassword nil to use RCW demonization.
 func WriteEntry(realPath string, decSlice []string, passwordIsNew bool, rcwPassword []byte) error {
+	return writeEntry(realPath, decSlice, passwordIsNew, true, rcwPassword)
+}
+
+// writeEntry is the implementation of WriteEntry.
+// If saveRevision is false, the previous version of the entry is not kept in the revision history
+// (for bookkeeping writes, such as HOTP counter increments, that would otherwise evict real revisions).
+func writeEntry(realPath string, decSlice []string, passwordIsNew, saveRevision bool, rcwPassword []byte) error {
+	// keep the previous version of the entry (if any) in the revision history
+	if saveRevision {
+		if err := history.Save(global.GetVanityPath(realPath)); err != nil {
+			return errors.New("unable to save revision history: " + err.Error())
+		}
+	}
+
 	err := os.WriteFile(realPath, crypt.EncryptBytes([]byte(strings.Join(clampTrailingWhitespace(decSlice), "\n")), rcwPassword), 0600)
 	if err != nil {
 		return errors.New("unable to write to file: " + err.Error())
@@ -28,12 +46,12 @@ func WriteEntry(realPath string, decSlice []string, passwordIsNew bool, rcwPassw
 
 	if decSlice != nil {
 		if passwordIsNew { // update age data when password changes
-			if decSlice[0] != "" { // if the password change was NOT a removal, update the age file
+			if decSlice[global.FieldPassword] != "" { // if the password change was NOT a removal, update the age file
 				if err = age.Entry(global.GetVanityPath(realPath), time.Now().Unix()); err != nil {
 					return errors.New("unable to update age data: " + err.Error())
 				}
 			} else { // if the password change was a removal, remove the associated age file
-				if err = syncclient.ShearRemote(global.GetVanityPath(realPath), true); err != nil {
+				if err = syncclient.ShearTargetsRemote([]string{global.GetVanityPath(realPath)}, true); err != nil {
 					return errors.New("unable to remove age data: " + err.Error())
 				}
 			}
@@ -43,74 +61,6 @@ func WriteEntry(realPath string, decSlice []string, passwordIsNew bool, rcwPassw
 	return nil
 }
 
-// EntryRefresh re-encrypts all libmutton entries with a new password
-// and optimizes each entry to ensure they are as slim as possible.
-func EntryRefresh(oldRCWPassword, newRCWPassword []byte, removeOldDir bool) error {
-	// ensure global.EntryRoot+"-new" and global.EntryRoot-"old" do not exist
-	dirEnds := []string{"-new", "-old"}
-	for i, dirEnd := range dirEnds {
-		if i == 1 && !removeOldDir {
-			if _, err := os.Stat(global.EntryRoot + "-old"); !os.IsNotExist(err) {
-				return errors.New("unable to refresh entries: \"" + global.EntryRoot + "-old\" already exists")
-			}
-		}
-		if err := os.RemoveAll(global.EntryRoot + dirEnd); err != nil {
-			return errors.New("unable to remove \"" + global.EntryRoot + dirEnd + "\": " + err.Error())
-		}
-	}
-
-	// create output directory structure (global.EntryRoot + "-new"/*)
-	entries, folders, err := synccommon.WalkEntryDir()
-	if err != nil {
-		return errors.New("unable to walk entry directory: " + err.Error())
-	}
-	for _, folder := range folders {
-		fullPath := global.EntryRoot + "-new" + strings.ReplaceAll(folder, "/", global.PathSeparator)
-		if err := os.MkdirAll(fullPath, 0700); err != nil {
-			return errors.New("unable to create temporary directory \"" + fullPath + "\": " + err.Error())
-		}
-	}
-
-	// decrypt, optimize, and re-encrypt each entry
-	for _, vanityPath := range entries {
-		realPath := global.GetRealPath(vanityPath)
-		encBytes, err := os.ReadFile(realPath)
-		if err != nil {
-			return errors.New("unable to open \"" + realPath + "\" for decryption: " + err.Error())
-		}
-		decBytes, err := wrappers.Decrypt(encBytes, oldRCWPassword, false)
-		if err != nil {
-			return err
-		}
-
-		// split & optimize entry
-		decSlice := clampTrailingWhitespace(strings.Split(string(decBytes), "\n"))
-
-		// re-encrypt the entry with the new password
-		encBytes = wrappers.Encrypt([]byte(strings.Join(decSlice, "\n")), newRCWPassword, true, false)
-
-		// write the entry to the new directory
-		if err = os.WriteFile(global.EntryRoot+"-new"+strings.ReplaceAll(vanityPath, "/", global.PathSeparator), encBytes, 0600); err != nil {
-			return errors.New("unable to write to file: " + err.Error())
-		}
-	}
-
-	// swap the new directory with the old one
-	if err = os.Rename(global.EntryRoot, global.EntryRoot+"-old"); err != nil {
-		return errors.New("unable to rename old directory: " + err.Error())
-	}
-	if err = os.Rename(global.EntryRoot+"-new", global.EntryRoot); err != nil {
-		return errors.New("unable to rename new directory: " + err.Error())
-	}
-
-	// generate new sanity check file
-	if err = RCWSanityCheckGen(newRCWPassword); err != nil {
-		return err
-	}
-
-	return nil
-}
-
 // VerifyEntries decrypts all entries to memory and returns an error if
 // any failures are encountered. Failures likely indicate corrupt entries.
 func VerifyEntries(rcwPassword []byte) error {
@@ -128,7 +78,7 @@ func VerifyEntries(rcwPassword []byte) error {
 		if err != nil {
 			return errors.New("unable to verify \"" + vanityPath + "\" (decryption failure): " + err.Error())
 		}
-		if len(decBytes) < 1 {
+		if len(decBytes) < 1 && !global.IsAttachment(vanityPath) {
 			return errors.New("unable to verify \"" + vanityPath + "\" (contains 0/nil bytes)")
 		}
 	}
@@ -139,10 +89,15 @@ func VerifyEntries(rcwPassword []byte) error {
 // If decSlice contains a note, it strips trailing newlines, carriage returns, and tabs from
 // each line in the note. Additionally, it removes single trailing spaces and truncates
 // multiple trailing spaces to two (for Markdown formatting).
+// Custom field lines are left untouched, as their values may intentionally end in whitespace.
 func clampTrailingWhitespace(decSlice []string) []string {
 	decSlice = back.RemoveTrailingEmptyStrings(decSlice)
-	if len(decSlice) >= 4 {
-		for i, noteLine := range decSlice[4:] {
+	if len(decSlice) >= global.FieldNotes {
+		for i, noteLine := range decSlice[global.FieldNotes:] {
+			if _, ok := parseCustomField(noteLine); ok {
+				continue
+			}
+
 			// remove trailing tabs, carriage returns, and newlines
 			noteLine = strings.TrimRight(noteLine, "\t\r\n")
 
@@ -159,13 +114,13 @@ func clampTrailingWhitespace(decSlice []string) []string {
 			switch endSpacesCount {
 			case 0:
 				// no trailing spaces
-				decSlice[i+4] = noteLine
+				decSlice[i+global.FieldNotes] = noteLine
 			case 1:
 				// remove the single trailing space
-				decSlice[i+4] = strings.TrimRight(noteLine, " ")
+				decSlice[i+global.FieldNotes] = strings.TrimRight(noteLine, " ")
 			default:
 				// truncate the trailing spaces to two
-				decSlice[i+4] = noteLine[:len(noteLine)-endSpacesCount+2]
+				decSlice[i+global.FieldNotes] = noteLine[:len(noteLine)-endSpacesCount+2]
 			}
 		}
 	}
@@ -200,18 +155,119 @@ func EntryIsNotEmpty(entryData []string) bool {
 	return false
 }
 
+// OTPParamsT contains the parameters needed to generate OTP tokens for an entry.
+type OTPParamsT struct {
+	Secret      string // base32-encoded secret
+	Algorithm   otp.Algorithm
+	Digits      otp.Digits
+	Period      uint // seconds (TOTP only)
+	IsHOTP      bool
+	Counter     uint64 // next counter value to use (HOTP only)
+	Encoder     otp.Encoder
+	Issuer      string // empty if not provided
+	AccountName string // empty if not provided
+}
+
+// Limits for parameters parsed from "otpauth://" URIs (out-of-range values would break token generation).
+const (
+	otpMinDigits = 6
+	otpMaxDigits = 10
+	otpMaxPeriod = 3600 // seconds
+)
+
+// ParseTOTP parses the TOTP field of an entry.
+// Accepted formats:
+// a bare base32 secret (SHA1, 6 digits, 30 seconds),
+// a base32 secret prefixed with "steam@" (Steam format),
+// a full "otpauth://totp/" URI (algorithm, digits, period, and issuer parameters are respected),
+// or a full "otpauth://hotp/" URI (counter-based; see GenHOTP).
+func ParseTOTP(secret string) (*OTPParamsT, error) {
+	secret = strings.TrimSpace(secret)
+	switch {
+	case strings.HasPrefix(secret, "steam@"):
+		return &OTPParamsT{Secret: secret[6:], Algorithm: otp.AlgorithmSHA1, Digits: 5, Period: 30, Encoder: otp.EncoderSteam}, nil
+	case strings.HasPrefix(strings.ToLower(secret), "otpauth://"):
+		key, err := otp.NewKeyFromURL(secret)
+		if err != nil {
+			return nil, errors.New("unable to parse otpauth URI: " + err.Error())
+		}
+		if key.Secret() == "" {
+			return nil, errors.New("otpauth URI does not contain a secret")
+		}
+		params := &OTPParamsT{Secret: key.Secret(), Algorithm: key.Algorithm(), Digits: key.Digits(), Encoder: key.Encoder(), Issuer: key.Issuer(), AccountName: key.AccountName()}
+		if params.Encoder != otp.EncoderSteam && (params.Digits < otpMinDigits || params.Digits > otpMaxDigits) {
+			return nil, errors.New("otpauth URI contains an invalid number of digits (must be " + strconv.Itoa(otpMinDigits) + "-" + strconv.Itoa(otpMaxDigits) + ")")
+		}
+		switch key.Type() {
+		case "totp":
+			if key.Period() < 1 || key.Period() > otpMaxPeriod {
+				return nil, errors.New("otpauth URI contains an invalid period (must be 1-" + strconv.Itoa(otpMaxPeriod) + " seconds)")
+			}
+			params.Period = uint(key.Period())
+		case "hotp":
+			counter, err := getHOTPCounter(secret)
+			if err != nil {
+				return nil, err
+			}
+			params.IsHOTP = true
+			params.Counter = counter
+		default:
+			return nil, errors.New("unsupported OTP type \"" + key.Type() + "\"")
+		}
+		if params.Encoder == otp.EncoderSteam {
+			params.Digits = 5
+		}
+		return params, nil
+	}
+	return &OTPParamsT{Secret: secret, Algorithm: otp.AlgorithmSHA1, Digits: otp.DigitsSix, Period: 30}, nil
+}
+
+// FormOTPAuthURI converts the TOTP field of an entry to an "otpauth://" URI (e.g. for QR
+// codes or exports). Complete URIs are returned unmodified; bare secrets are labeled with
+// issuer (typically the entry name) and accountName (typically the username), either of which may be empty.
+func FormOTPAuthURI(secret, issuer, accountName string) (string, error) {
+	secret = strings.TrimSpace(secret)
+	params, err := ParseTOTP(secret)
+	if err != nil {
+		return "", err
+	}
+	if strings.HasPrefix(strings.ToLower(secret), "otpauth://") {
+		return secret, nil // already a complete URI
+	}
+
+	// form URI from bare secret
+	label := issuer
+	if accountName != "" {
+		if label != "" {
+			label += ":"
+		}
+		label += accountName
+	}
+	query := url.Values{}
+	query.Set("secret", params.Secret)
+	if issuer != "" {
+		query.Set("issuer", issuer)
+	}
+	if params.Encoder != "" {
+		query.Set("encoder", string(params.Encoder))
+		query.Set("digits", params.Digits.String())
+	}
+	return (&url.URL{Scheme: "otpauth", Host: "totp", Path: "/" + label, RawQuery: query.Encode()}).String(), nil
+}
+
 // GenTOTP generates a TOTP token from a secret.
-// Prefix `secret` with "steam@" for Steam TOTP format.
+// See ParseTOTP for accepted secret formats.
+// HOTP secrets are rejected, as their counter must be persisted (use GenHOTP).
 func GenTOTP(secret string, time time.Time) (string, error) {
-	var totpToken string
-	var err error
-
-	if strings.HasPrefix(secret, "steam@") {
-		totpToken, err = totp.GenerateCodeCustom(secret[6:], time, totp.ValidateOpts{Period: 30, Digits: 5, Encoder: otp.EncoderSteam})
-	} else {
-		totpToken, err = totp.GenerateCode(secret, time)
+	params, err := ParseTOTP(secret)
+	if err != nil {
+		return "", errors.New("unable to generate TOTP token: " + err.Error())
+	}
+	if params.IsHOTP {
+		return "", errors.New("unable to generate TOTP token: secret is counter-based (HOTP)")
 	}
 
+	totpToken, err := totp.GenerateCodeCustom(params.Secret, time, totp.ValidateOpts{Period: params.Period, Digits: params.Digits, Algorithm: params.Algorithm, Encoder: params.Encoder})
 	if err != nil {
 		return "", errors.New("unable to generate TOTP token: " + err.Error())
 	}
@@ -0,0 +1,43 @@
+package core
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseTOTPRejectsMalformedURIs(t *testing.T) {
+	tests := []struct {
+		name string
+		uri  string
+	}{
+		{"overflowing digits", "otpauth://totp/test?secret=JBSWY3DPEHPK3PXP&digits=18446744073709551615"},
+		{"too few digits", "otpauth://totp/test?secret=JBSWY3DPEHPK3PXP&digits=1"},
+		{"too many digits", "otpauth://totp/test?secret=JBSWY3DPEHPK3PXP&digits=11"},
+		{"zero period", "otpauth://totp/test?secret=JBSWY3DPEHPK3PXP&period=0"},
+		{"excessive period", "otpauth://totp/test?secret=JBSWY3DPEHPK3PXP&period=3601"},
+		{"HOTP with invalid digits", "otpauth://hotp/test?secret=JBSWY3DPEHPK3PXP&counter=0&digits=18446744073709551615"},
+		{"missing secret", "otpauth://totp/test?digits=6"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := ParseTOTP(test.uri); err == nil {
+				t.Fatal("expected an error")
+			}
+			if _, err := GenTOTP(test.uri, time.Now()); err == nil {
+				t.Fatal("expected an error")
+			}
+		})
+	}
+}
+
+func TestParseTOTPAcceptsValidURIs(t *testing.T) {
+	for _, uri := range []string{
+		"JBSWY3DPEHPK3PXP",
+		"otpauth://totp/test?secret=JBSWY3DPEHPK3PXP&digits=8&period=60&algorithm=SHA256",
+		"otpauth://totp/test?secret=JBSWY3DPEHPK3PXP&digits=10&period=3600",
+	} {
+		if _, err := GenTOTP(uri, time.Now()); err != nil {
+			t.Fatalf("%s: %v", uri, err)
+		}
+	}
+}
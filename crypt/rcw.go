@@ -28,6 +28,22 @@ func RCWDArgument() {
 // and returns the contents as a slice of (trimmed) strings.
 // Leave rcwPassword nil to use RCW demonization.
 func DecryptFileToSlice(realPath string, rcwPassword []byte) ([]string, error) {
+	decBytes, err := DecryptFileToBytes(realPath, rcwPassword)
+	if err != nil {
+		return nil, err
+	}
+	return strings.Split(string(decBytes), "\n"), nil
+}
+
+// DecryptFileToBytes decrypts an RCW wrapped file
+// and returns the raw contents.
+// Leave rcwPassword nil to use RCW demonization.
+func DecryptFileToBytes(realPath string, rcwPassword []byte) ([]byte, error) {
+	// complete any interrupted entry refresh, so that realPath matches the current sanity check file
+	if _, err := global.RecoverEntryRefresh(); err != nil {
+		return nil, err
+	}
+
 	// read encrypted file
 	encBytes, err := os.ReadFile(realPath)
 	if err != nil {
@@ -40,7 +56,7 @@ func DecryptFileToSlice(realPath string, rcwPassword []byte) ([]string, error) {
 		// if rcwPassword is still nil, the daemon is already running;
 		// use it to encrypt the data
 		if rcwPassword == nil {
-			return strings.Split(string(daemon.GetDec(encBytes)), "\n"), nil
+			return daemon.GetDec(encBytes), nil
 		}
 	}
 
@@ -50,7 +66,7 @@ func DecryptFileToSlice(realPath string, rcwPassword []byte) ([]string, error) {
 	if err != nil {
 		return nil, errors.New("unable to decrypt \"" + realPath + "\": " + err.Error())
 	}
-	return strings.Split(string(decBytes), "\n"), nil
+	return decBytes, nil
 }
 
 // EncryptBytes encrypts a byte slice using RCW and returns the encrypted data.
@@ -79,6 +95,9 @@ func launchRCWDProcess() []byte {
 	if daemon.IsOpen() {
 		return nil
 	}
+	// complete any interrupted entry refresh, so that the password is checked against the current sanity check file
+	// (errors are ignored here; they are reported by the subsequent entry access)
+	_, _ = global.RecoverEntryRefresh()
 	var password []byte
 	if RetryPassword {
 		for {
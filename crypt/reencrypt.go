@@ -0,0 +1,92 @@
+package crypt
+
+import (
+	"errors"
+	"os"
+	"sync"
+
+	"github.com/rwinkhart/rcw/wrappers"
+)
+
+// ReencryptWorkers is the number of files re-encrypted concurrently by Reencrypt.
+// Each worker requires roughly 1 GiB of memory for key derivation, so only one is used by default
+// (clients on devices with plenty of memory may opt in to more).
+var ReencryptWorkers = 1
+
+// ReencryptJobT is a file to be re-encrypted by Reencrypt.
+type ReencryptJobT struct {
+	SrcPath   string
+	DstPath   string
+	Transform func(decBytes []byte) []byte // optional; applied to the decrypted contents before re-encryption
+	Done      func() error                 // optional; called once the destination file has been written and synced to disk
+}
+
+// Reencrypt decrypts each job's source file with oldRCWPassword and writes
+// it to the job's destination path, encrypted with newRCWPassword.
+// Up to ReencryptWorkers jobs are processed concurrently.
+// Processing stops at the first failure. Neither password is zeroized.
+func Reencrypt(jobs []ReencryptJobT, oldRCWPassword, newRCWPassword []byte) error {
+	jobChan := make(chan ReencryptJobT)
+	var wg sync.WaitGroup
+	var errOnce sync.Once
+	var firstErr error
+	failed := make(chan struct{})
+
+	for range min(ReencryptWorkers, len(jobs)) {
+		wg.Go(func() {
+			for job := range jobChan {
+				if err := reencryptFile(job, oldRCWPassword, newRCWPassword); err != nil {
+					errOnce.Do(func() {
+						firstErr = err
+						close(failed)
+					})
+				}
+			}
+		})
+	}
+
+sendJobs:
+	for _, job := range jobs {
+		select {
+		case jobChan <- job:
+		case <-failed:
+			break sendJobs
+		}
+	}
+	close(jobChan)
+	wg.Wait()
+	return firstErr
+}
+
+// reencryptFile re-encrypts a single file (see Reencrypt).
+func reencryptFile(job ReencryptJobT, oldRCWPassword, newRCWPassword []byte) error {
+	encBytes, err := os.ReadFile(job.SrcPath)
+	if err != nil {
+		return errors.New("unable to open \"" + job.SrcPath + "\" for decryption: " + err.Error())
+	}
+	decBytes, err := wrappers.Decrypt(encBytes, oldRCWPassword, false)
+	if err != nil {
+		return errors.New("unable to decrypt \"" + job.SrcPath + "\": " + err.Error())
+	}
+	if job.Transform != nil {
+		decBytes = job.Transform(decBytes)
+	}
+	f, err := os.OpenFile(job.DstPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return errors.New("unable to write to file: " + err.Error())
+	}
+	_, err = f.Write(wrappers.Encrypt(decBytes, newRCWPassword, true, false))
+	if err == nil {
+		err = f.Sync() // ensure the file is on disk before it is reported as done
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return errors.New("unable to write to file: " + err.Error())
+	}
+	if job.Done != nil {
+		return job.Done()
+	}
+	return nil
+}
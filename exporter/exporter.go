@@ -0,0 +1,130 @@
+package exporter
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/rwinkhart/libmutton/core"
+	"github.com/rwinkhart/libmutton/crypt"
+	"github.com/rwinkhart/libmutton/global"
+	"github.com/rwinkhart/libmutton/synccommon"
+)
+
+// FormatT is an export file format.
+type FormatT uint8
+
+const (
+	FormatJSON       FormatT = iota // libmutton-specific JSON (see jsonExportT)
+	FormatCSV                       // one row per entry; custom fields are stored in additional columns
+	FormatKeePassXML                // KeePass 2.x XML (importable by KeePass and KeePassXC)
+)
+
+// entryT is a decrypted entry and its metadata.
+type entryT struct {
+	vanityPath  string
+	entry       *core.EntryT
+	modified    int64  // UNIX timestamp of the last modification
+	passwordAge *int64 // UNIX timestamp from the entry's age file (nil if not aged)
+}
+
+// Vault decrypts all entries and writes them (along with all folders)
+// to a single file at outputPath in the specified format.
+// Entries include their TOTP secrets and password age timestamps.
+// Attachments are not exported.
+// If exportPassphrase is non-empty, the export is encrypted with it (see DecryptExport), so that it is never written to disk in plaintext.
+// Leave rcwPassword nil to use RCW demonization.
+func Vault(outputPath string, format FormatT, exportPassphrase, rcwPassword []byte) error {
+	entries, folders, err := getEntries(rcwPassword)
+	if err != nil {
+		return err
+	}
+
+	var output []byte
+	switch format {
+	case FormatJSON:
+		output, err = formatJSON(entries, folders)
+	case FormatCSV:
+		output, err = formatCSV(entries)
+	case FormatKeePassXML:
+		output, err = formatKeePassXML(entries, folders)
+	default:
+		return errors.New("unsupported export format")
+	}
+	if err != nil {
+		return errors.New("unable to format export: " + err.Error())
+	}
+
+	if len(exportPassphrase) > 0 {
+		// clone exportPassphrase, as it is zeroized after use
+		encOutput := crypt.EncryptBytes(output, bytes.Clone(exportPassphrase))
+		clear(output)
+		output = encOutput
+	}
+	err = os.WriteFile(outputPath, output, 0600)
+	clear(output)
+	if err != nil {
+		return errors.New("unable to write export: " + err.Error())
+	}
+	return nil
+}
+
+// DecryptExport decrypts an export created by Vault with a non-empty exportPassphrase.
+// Returns: the plaintext export (in the format it was created with).
+func DecryptExport(exportPath string, exportPassphrase []byte) ([]byte, error) {
+	if len(exportPassphrase) == 0 {
+		return nil, errors.New("export passphrase cannot be empty")
+	}
+	// clone exportPassphrase, as it is zeroized after use
+	return crypt.DecryptFileToBytes(exportPath, bytes.Clone(exportPassphrase))
+}
+
+// getEntries decrypts all entries and collects their metadata.
+// Returns: the entries, the vanity paths of all folders (both sorted).
+// Leave rcwPassword nil to use RCW demonization.
+func getEntries(rcwPassword []byte) ([]entryT, []string, error) {
+	fileList, dirList, err := synccommon.WalkEntryDir()
+	if err != nil {
+		return nil, nil, errors.New("unable to walk entry directory: " + err.Error())
+	}
+	slices.Sort(fileList)
+
+	var entries []entryT
+	for _, vanityPath := range fileList {
+		if global.IsAttachment(vanityPath) {
+			continue
+		}
+		realPath := global.GetRealPath(vanityPath)
+		// clone rcwPassword for each decryption, as it is zeroized after use (nil is preserved for RCW demonization)
+		decSlice, err := crypt.DecryptFileToSlice(realPath, bytes.Clone(rcwPassword))
+		if err != nil {
+			return nil, nil, errors.New("unable to decrypt \"" + vanityPath + "\": " + err.Error())
+		}
+		exported := entryT{vanityPath: vanityPath, entry: core.ParseEntry(decSlice)}
+		if info, err := os.Stat(realPath); err == nil {
+			exported.modified = info.ModTime().Unix()
+		}
+		if info, err := os.Stat(global.GetRealAgePath(vanityPath)); err == nil {
+			passwordAge := info.ModTime().Unix()
+			exported.passwordAge = &passwordAge
+		}
+		entries = append(entries, exported)
+	}
+
+	var folders []string
+	for _, vanityPath := range dirList {
+		if vanityPath != "" && vanityPath != "/" {
+			folders = append(folders, vanityPath)
+		}
+	}
+	slices.Sort(folders)
+	return entries, folders, nil
+}
+
+// splitVanityPath splits a vanity path into its containing folder ("" for the root) and name.
+func splitVanityPath(vanityPath string) (string, string) {
+	i := strings.LastIndex(vanityPath, "/")
+	return vanityPath[:max(i, 0)], vanityPath[i+1:]
+}
@@ -0,0 +1,234 @@
+package exporter
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rwinkhart/libmutton/core"
+	"github.com/rwinkhart/libmutton/global"
+)
+
+// jsonExportT is the structure of a JSON export.
+type jsonExportT struct {
+	Version  string            `json:"libmuttonVersion"`
+	Exported int64             `json:"exported"` // UNIX timestamp
+	Folders  []string          `json:"folders"`  // vanity paths
+	Entries  []jsonExportEntry `json:"entries"`
+}
+
+// jsonExportEntry is a single entry in a JSON export.
+type jsonExportEntry struct {
+	VanityPath  string              `json:"vanityPath"`
+	Password    string              `json:"password,omitempty"`
+	Username    string              `json:"username,omitempty"`
+	TOTP        string              `json:"totp,omitempty"`
+	URL         string              `json:"url,omitempty"`
+	Notes       []string            `json:"notes,omitempty"`
+	Custom      []core.CustomFieldT `json:"custom,omitempty"`
+	Modified    int64               `json:"modified"`              // UNIX timestamp
+	PasswordAge *int64              `json:"passwordAge,omitempty"` // UNIX timestamp
+}
+
+// formatJSON formats entries and folders as JSON.
+func formatJSON(entries []entryT, folders []string) ([]byte, error) {
+	export := jsonExportT{Version: global.LibmuttonVersion, Exported: time.Now().Unix(), Folders: folders, Entries: make([]jsonExportEntry, len(entries))}
+	if export.Folders == nil {
+		export.Folders = []string{}
+	}
+	for i, exported := range entries {
+		export.Entries[i] = jsonExportEntry{
+			VanityPath:  exported.vanityPath,
+			Password:    exported.entry.Password,
+			Username:    exported.entry.Username,
+			TOTP:        exported.entry.TOTP,
+			URL:         exported.entry.URL,
+			Notes:       exported.entry.Notes,
+			Custom:      exported.entry.Custom,
+			Modified:    exported.modified,
+			PasswordAge: exported.passwordAge,
+		}
+	}
+	return json.MarshalIndent(export, "", "  ")
+}
+
+// formatCSV formats entries as CSV.
+// Columns: folder, name, password, username, totp, url, notes,
+// modified (RFC 3339), password_age (RFC 3339), followed by one column per custom field name.
+func formatCSV(entries []entryT) ([]byte, error) {
+	// determine custom field columns
+	var customNames []string
+	for _, exported := range entries {
+		for _, customField := range exported.entry.Custom {
+			if !slices.Contains(customNames, customField.Name) {
+				customNames = append(customNames, customField.Name)
+			}
+		}
+	}
+	slices.Sort(customNames)
+
+	var buffer bytes.Buffer
+	writer := csv.NewWriter(&buffer)
+	header := append([]string{"folder", "name", "password", "username", "totp", "url", "notes", "modified", "password_age"}, customNames...)
+	if err := writer.Write(header); err != nil {
+		return nil, err
+	}
+	for _, exported := range entries {
+		folder, name := splitVanityPath(exported.vanityPath)
+		var passwordAge string
+		if exported.passwordAge != nil {
+			passwordAge = time.Unix(*exported.passwordAge, 0).UTC().Format(time.RFC3339)
+		}
+		row := []string{
+			strings.TrimPrefix(folder, "/"), name,
+			exported.entry.Password, exported.entry.Username, exported.entry.TOTP, exported.entry.URL,
+			strings.Join(exported.entry.Notes, "\n"),
+			time.Unix(exported.modified, 0).UTC().Format(time.RFC3339), passwordAge,
+		}
+		for _, customName := range customNames {
+			var value string
+			if customField := core.GetCustomField(exported.entry, customName); customField != nil {
+				value = customField.Value
+			}
+			row = append(row, value)
+		}
+		if err := writer.Write(row); err != nil {
+			return nil, err
+		}
+	}
+	writer.Flush()
+	return buffer.Bytes(), writer.Error()
+}
+
+// KeePass 2.x XML structure (only the elements required for import)
+type (
+	keePassFileT struct {
+		XMLName xml.Name      `xml:"KeePassFile"`
+		Meta    keePassMetaT  `xml:"Meta"`
+		Root    keePassGroupT `xml:"Root>Group"`
+	}
+	keePassMetaT struct {
+		Generator    string `xml:"Generator"`
+		DatabaseName string `xml:"DatabaseName"`
+	}
+	keePassGroupT struct {
+		UUID    string           `xml:"UUID"`
+		Name    string           `xml:"Name"`
+		Entries []keePassEntryT  `xml:"Entry"`
+		Groups  []*keePassGroupT `xml:"Group"`
+	}
+	keePassEntryT struct {
+		UUID    string           `xml:"UUID"`
+		Times   keePassTimesT    `xml:"Times"`
+		Strings []keePassStringT `xml:"String"`
+	}
+	keePassTimesT struct {
+		CreationTime         string `xml:"CreationTime"`
+		LastModificationTime string `xml:"LastModificationTime"`
+		LastAccessTime       string `xml:"LastAccessTime"`
+	}
+	keePassStringT struct {
+		Key   string        `xml:"Key"`
+		Value keePassValueT `xml:"Value"`
+	}
+	keePassValueT struct {
+		Value           string `xml:",chardata"`
+		ProtectInMemory string `xml:"ProtectInMemory,attr,omitempty"`
+	}
+)
+
+// keePassReservedKeys are the KeePass string keys used for standard libmutton fields.
+var keePassReservedKeys = []string{"Title", "UserName", "Password", "URL", "Notes", "otp", "Password Age"}
+
+// formatKeePassXML formats entries and folders as KeePass 2.x XML.
+// Folders are mapped to groups, TOTP secrets are stored in the KeePassXC "otp" field
+// (as "otpauth://" URIs), and password age timestamps are stored in a "Password Age" field (RFC 3339).
+// Custom fields named after a reserved or previously used key are given a numeric suffix (e.g. "URL (1)").
+func formatKeePassXML(entries []entryT, folders []string) ([]byte, error) {
+	root := &keePassGroupT{UUID: newKeePassUUID(), Name: "libmutton"}
+	groups := map[string]*keePassGroupT{"": root}
+	// getGroup returns the group for a folder, creating it (and its parents) if needed
+	var getGroup func(folder string) *keePassGroupT
+	getGroup = func(folder string) *keePassGroupT {
+		if group, ok := groups[folder]; ok {
+			return group
+		}
+		parentFolder, name := splitVanityPath(folder)
+		parent := getGroup(parentFolder)
+		group := &keePassGroupT{UUID: newKeePassUUID(), Name: name}
+		parent.Groups = append(parent.Groups, group)
+		groups[folder] = group
+		return group
+	}
+	for _, folder := range folders {
+		getGroup(folder)
+	}
+
+	for _, exported := range entries {
+		folder, name := splitVanityPath(exported.vanityPath)
+		modified := time.Unix(exported.modified, 0).UTC().Format(time.RFC3339)
+		keePassEntry := keePassEntryT{
+			UUID:  newKeePassUUID(),
+			Times: keePassTimesT{CreationTime: modified, LastModificationTime: modified, LastAccessTime: modified},
+		}
+		addString := func(key, value string, protect bool) {
+			if value == "" && key != "Title" && key != "Password" && key != "UserName" && key != "URL" && key != "Notes" {
+				return
+			}
+			str := keePassStringT{Key: key, Value: keePassValueT{Value: value}}
+			if protect {
+				str.Value.ProtectInMemory = "True"
+			}
+			keePassEntry.Strings = append(keePassEntry.Strings, str)
+		}
+		addString("Title", name, false)
+		addString("UserName", exported.entry.Username, false)
+		addString("Password", exported.entry.Password, true)
+		addString("URL", exported.entry.URL, false)
+		addString("Notes", strings.Join(exported.entry.Notes, "\n"), false)
+		if exported.entry.TOTP != "" {
+			totpURI, err := core.FormOTPAuthURI(exported.entry.TOTP, name, exported.entry.Username)
+			if err != nil {
+				totpURI = exported.entry.TOTP // export invalid secrets as-is rather than losing them
+			}
+			addString("otp", totpURI, true)
+		}
+		if exported.passwordAge != nil {
+			addString("Password Age", time.Unix(*exported.passwordAge, 0).UTC().Format(time.RFC3339), false)
+		}
+		usedKeys := make(map[string]bool, len(keePassReservedKeys)+len(exported.entry.Custom))
+		for _, key := range keePassReservedKeys {
+			usedKeys[key] = true
+		}
+		for _, customField := range exported.entry.Custom {
+			key := customField.Name
+			for i := 1; usedKeys[key]; i++ {
+				key = customField.Name + " (" + strconv.Itoa(i) + ")"
+			}
+			usedKeys[key] = true
+			addString(key, customField.Value, customField.Hidden)
+		}
+		group := getGroup(folder)
+		group.Entries = append(group.Entries, keePassEntry)
+	}
+
+	output, err := xml.MarshalIndent(keePassFileT{Meta: keePassMetaT{Generator: "libmutton " + global.LibmuttonVersion, DatabaseName: "libmutton"}, Root: *root}, "", "\t")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), output...), nil
+}
+
+// newKeePassUUID returns a random, base64-encoded UUID (as used by KeePass XML).
+func newKeePassUUID() string {
+	uuid := make([]byte, 16)
+	_, _ = rand.Read(uuid)
+	return base64.StdEncoding.EncodeToString(uuid)
+}
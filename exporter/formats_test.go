@@ -0,0 +1,51 @@
+package exporter
+
+import (
+	"encoding/xml"
+	"slices"
+	"testing"
+
+	"github.com/rwinkhart/libmutton/core"
+)
+
+func TestFormatKeePassXMLSuffixesCollidingKeys(t *testing.T) {
+	entry := &core.EntryT{
+		Password: "password",
+		URL:      "https://example.com",
+		Custom: []core.CustomFieldT{
+			{Name: "URL", Value: "https://example.org"},
+			{Name: "Notes", Value: "custom notes"},
+			{Name: "otp", Value: "not a secret"},
+			{Name: "PIN", Value: "1234", Hidden: true},
+			{Name: "PIN", Value: "5678", Hidden: true},
+			{Name: "URL (1)", Value: "https://example.net"},
+		},
+	}
+	output, err := formatKeePassXML([]entryT{{vanityPath: "/entry", entry: entry}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var keePassFile keePassFileT
+	if err = xml.Unmarshal(output, &keePassFile); err != nil {
+		t.Fatal(err)
+	}
+	if len(keePassFile.Root.Entries) != 1 {
+		t.Fatalf("expected 1 entry, got %d", len(keePassFile.Root.Entries))
+	}
+
+	var keys []string
+	values := map[string]string{}
+	for _, str := range keePassFile.Root.Entries[0].Strings {
+		keys = append(keys, str.Key)
+		values[str.Key] = str.Value.Value
+	}
+	want := []string{"Title", "UserName", "Password", "URL", "Notes", "URL (1)", "Notes (1)", "otp (1)", "PIN", "PIN (1)", "URL (1) (1)"}
+	if !slices.Equal(keys, want) {
+		t.Fatalf("unexpected keys %q; want %q", keys, want)
+	}
+	for key, value := range map[string]string{"URL": "https://example.com", "URL (1)": "https://example.org", "PIN (1)": "5678", "URL (1) (1)": "https://example.net"} {
+		if values[key] != value {
+			t.Errorf("unexpected value %q for key %q; want %q", values[key], key, value)
+		}
+	}
+}
@@ -12,6 +12,12 @@ const (
 	FSPath  = "\u259e" // ▞ Path separator
 	FSMisc  = "\u259f" // ▟ Misc. field separator (if \u259d is already used)
 
+	FieldPassword = 0 // Entry line index: password
+	FieldUsername = 1 // Entry line index: username
+	FieldTOTP     = 2 // Entry line index: TOTP secret
+	FieldURL      = 3 // Entry line index: URL
+	FieldNotes    = 4 // Entry line index: first line of notes (notes continue to the end of the entry)
+
 	ErrorSyncProcess = 104
 	ErrorDecryption  = 105
 	ErrorEncryption  = 106
@@ -18,6 +18,7 @@ var (
 	CfgDir     = fruityHome + "/config"                                                      // Path to libmutton configuration directory
 	CfgPath    = CfgDir + "/libmuttoncfg.json"                                               // Path to libmutton configuration file
 	AgeDir     = CfgDir + "/age"                                                             // Path to libmutton password age directory
+	HistDir    = CfgDir + "/history"                                                         // Path to libmutton entry revision history directory
 	SSHDir     = fruityHome + "/ssh"                                                         // Path to SSH directory
 )
 
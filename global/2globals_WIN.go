@@ -9,6 +9,7 @@ var (
 	CfgDir    = back.Home + "\\AppData\\Local\\libmutton\\config"  // Path to libmutton configuration directory
 	CfgPath   = CfgDir + "\\libmuttoncfg.json"                     // Path to libmutton configuration file
 	AgeDir    = CfgDir + "\\age"                                   // Path to libmutton password age directory
+	HistDir   = CfgDir + "\\history"                               // Path to libmutton entry revision history directory
 	SSHDir    = back.Home + "\\.ssh"                               // Path to SSH directory
 )
 
@@ -6,3 +6,19 @@ import "strings"
 func GetRealAgePath(vanityPath string) string {
 	return AgeDir + PathSeparator + strings.ReplaceAll(vanityPath, "/", FSPath)
 }
+
+// GetRealHistPath returns the full path to the revision history directory of an entry (given the vanity path)
+func GetRealHistPath(vanityPath string) string {
+	return HistDir + PathSeparator + strings.ReplaceAll(vanityPath, "/", FSPath)
+}
+
+// GetAttachmentVanityPath returns the vanity path of an attachment (given the vanity path of its entry and the attachment name).
+// Attachments are stored next to their entry so that they are synchronized along with it.
+func GetAttachmentVanityPath(vanityPath, name string) string {
+	return vanityPath + FSMisc + name
+}
+
+// IsAttachment returns whether a vanity path belongs to an attachment rather than an entry.
+func IsAttachment(vanityPath string) bool {
+	return strings.Contains(vanityPath[strings.LastIndex(vanityPath, "/")+1:], FSMisc)
+}
@@ -12,6 +12,11 @@ import (
 func DirInit(preserveOldCfgDir bool) (*string, error) {
 	var err error
 
+	// complete any interrupted entry refresh (otherwise, EntryRoot may be recreated empty while it is swapped out)
+	if _, err = RecoverEntryRefresh(); err != nil {
+		return nil, err
+	}
+
 	// create EntryRoot
 	if err = os.MkdirAll(EntryRoot, 0700); err != nil {
 		return nil, errors.New("unable to create \"" + EntryRoot + "\": " + err.Error())
@@ -40,5 +45,10 @@ func DirInit(preserveOldCfgDir bool) (*string, error) {
 		return nil, errors.New("unable to create \"" + AgeDir + "\": " + err.Error())
 	}
 
+	// create revision history directory
+	if err = os.MkdirAll(HistDir, 0700); err != nil {
+		return nil, errors.New("unable to create \"" + HistDir + "\": " + err.Error())
+	}
+
 	return oldDeviceID, nil
 }
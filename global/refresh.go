@@ -0,0 +1,165 @@
+package global
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"time"
+)
+
+// EntryRefresh (see core.EntryRefresh) progress is recorded in a journal file so that an
+// interrupted refresh can be recovered from (see RecoverEntryRefresh). A refresh has two phases:
+//   - RefreshPhaseStage: re-encrypted copies of EntryRoot and HistDir (and a new sanity check and key generation file)
+//     are written alongside the originals ("-new" suffix); nothing in use is modified.
+//     Each completed file is recorded in the progress file (see GetRefreshProgressPath) so that staging can be resumed
+//   - RefreshPhaseSwap: the staged copies are swapped in (originals are kept with an "-old" suffix)
+//
+// The journal lives in this package (rather than in core) so that recovery can be run
+// by every entry point (e.g. DirInit, syncclient.RunJob, and crypt's decryption paths).
+const (
+	RefreshPhaseStage = "stage"
+	RefreshPhaseSwap  = "swap"
+)
+
+// refreshJournalT is the structure of the EntryRefresh journal file.
+type refreshJournalT struct {
+	Phase   string `json:"phase"`
+	Updated int64  `json:"updated"` // UNIX timestamp
+}
+
+// getRefreshJournalPath returns the path to the EntryRefresh journal file.
+func getRefreshJournalPath() string {
+	return CfgDir + PathSeparator + "refresh.json"
+}
+
+// GetRefreshProgressPath returns the path to the EntryRefresh progress file.
+func GetRefreshProgressPath() string {
+	return CfgDir + PathSeparator + "refresh-progress"
+}
+
+// getRefreshStagedFiles returns the paths of the files in CfgDir that are staged
+// (with a "-new" suffix) and swapped in by EntryRefresh.
+func getRefreshStagedFiles() []string {
+	return []string{CfgDir + PathSeparator + "sanity.rcw", CfgDir + PathSeparator + "keygen"}
+}
+
+// GetRefreshPhase returns the phase of an interrupted (or in-progress) EntryRefresh ("" if there is none).
+func GetRefreshPhase() (string, error) {
+	journalBytes, err := os.ReadFile(getRefreshJournalPath())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", nil
+		}
+		return "", errors.New("unable to read refresh journal: " + err.Error())
+	}
+	var journal refreshJournalT
+	if err = json.Unmarshal(journalBytes, &journal); err != nil || (journal.Phase != RefreshPhaseStage && journal.Phase != RefreshPhaseSwap) {
+		// the journal is written atomically, so this should never happen;
+		// the refresh is still staging as long as the original entries have not been swapped out
+		_, newErr := os.Stat(EntryRoot + "-new")
+		_, oldErr := os.Stat(EntryRoot)
+		if newErr == nil && oldErr == nil {
+			return RefreshPhaseStage, nil
+		}
+		return RefreshPhaseSwap, nil
+	}
+	return journal.Phase, nil
+}
+
+// RecoverEntryRefresh completes an EntryRefresh that was interrupted while swapping in
+// the re-encrypted entries. An EntryRefresh that was interrupted while staging never modified
+// the original entries, so its staged output is left in place to be resumed (or rolled back)
+// by the next EntryRefresh.
+// It is called automatically by DirInit, syncclient.RunJob, and crypt's decryption/encryption paths.
+// Returns: whether an interrupted refresh was completed.
+func RecoverEntryRefresh() (bool, error) {
+	phase, err := GetRefreshPhase()
+	if err != nil {
+		return false, err
+	}
+	if phase != RefreshPhaseSwap {
+		return false, nil
+	}
+	if err = RollForwardEntryRefresh(); err != nil {
+		return false, errors.New("unable to recover from interrupted entry refresh: " + err.Error())
+	}
+	return true, nil
+}
+
+// RollBackEntryRefresh removes all staged output of an interrupted EntryRefresh.
+func RollBackEntryRefresh() error {
+	stagedPaths := []string{EntryRoot + "-new", HistDir + "-new", GetRefreshProgressPath()}
+	for _, stagedFile := range getRefreshStagedFiles() {
+		stagedPaths = append(stagedPaths, stagedFile+"-new")
+	}
+	for _, stagedPath := range stagedPaths {
+		if err := os.RemoveAll(stagedPath); err != nil {
+			return errors.New("unable to remove \"" + stagedPath + "\": " + err.Error())
+		}
+	}
+	return removeRefreshJournal()
+}
+
+// RollForwardEntryRefresh swaps the staged output of an EntryRefresh with the originals.
+// It is idempotent, so it can safely be re-run after being interrupted.
+func RollForwardEntryRefresh() error {
+	for _, dirRoot := range []string{EntryRoot, HistDir} {
+		if _, err := os.Stat(dirRoot + "-new"); os.IsNotExist(err) {
+			continue // already swapped
+		}
+		if _, err := os.Stat(dirRoot); err == nil {
+			if err = os.Rename(dirRoot, dirRoot+"-old"); err != nil {
+				return errors.New("unable to rename old directory: " + err.Error())
+			}
+		}
+		if err := os.Rename(dirRoot+"-new", dirRoot); err != nil {
+			return errors.New("unable to rename new directory: " + err.Error())
+		}
+	}
+	for _, stagedFile := range getRefreshStagedFiles() {
+		if _, err := os.Stat(stagedFile + "-new"); err == nil {
+			if err = os.Rename(stagedFile+"-new", stagedFile); err != nil {
+				return errors.New("unable to replace \"" + stagedFile + "\": " + err.Error())
+			}
+		}
+	}
+	if err := os.Remove(GetRefreshProgressPath()); err != nil && !os.IsNotExist(err) {
+		return errors.New("unable to remove refresh progress file: " + err.Error())
+	}
+	return removeRefreshJournal()
+}
+
+// WriteRefreshJournal atomically records the current EntryRefresh phase.
+func WriteRefreshJournal(phase string) error {
+	journalBytes, err := json.Marshal(&refreshJournalT{Phase: phase, Updated: time.Now().Unix()})
+	if err != nil {
+		return errors.New("unable to marshal refresh journal: " + err.Error())
+	}
+	journalPath := getRefreshJournalPath()
+	f, err := os.OpenFile(journalPath+"-tmp", os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return errors.New("unable to write refresh journal: " + err.Error())
+	}
+	_, err = f.Write(journalBytes)
+	if err == nil {
+		err = f.Sync() // ensure the journal is on disk before any directories are modified
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(journalPath+"-tmp", journalPath)
+	}
+	if err != nil {
+		return errors.New("unable to write refresh journal: " + err.Error())
+	}
+	return nil
+}
+
+// removeRefreshJournal marks the EntryRefresh as finished (or rolled back).
+func removeRefreshJournal() error {
+	if err := os.Remove(getRefreshJournalPath()); err != nil && !os.IsNotExist(err) {
+		return errors.New("unable to remove refresh journal: " + err.Error())
+	}
+	return nil
+}
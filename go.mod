@@ -3,6 +3,8 @@ module github.com/rwinkhart/libmutton
 go 1.26.0
 
 require (
+	github.com/boombuler/barcode v1.1.0
+	github.com/makiuchi-d/gozxing v0.1.1
 	github.com/pkg/sftp v1.13.10
 	github.com/pquerna/otp v1.5.0
 	github.com/rwinkhart/go-boilerplate v0.3.1
@@ -13,9 +15,10 @@ require (
 
 require (
 	github.com/Microsoft/go-winio v0.6.2 // indirect
-	github.com/boombuler/barcode v1.1.0 // indirect
 	github.com/kr/fs v0.1.0 // indirect
 	github.com/rwinkhart/peercred-mini v0.1.4 // indirect
+	golang.org/x/text v0.34.0 // indirect
+	golang.org/x/xerrors v0.0.0-20200804184101-5ec99f83aff1 // indirect
 )
 
 replace golang.org/x/sys => github.com/rwinkhart/sys v0.41.0
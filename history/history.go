@@ -0,0 +1,213 @@
+package history
+
+import (
+	"errors"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rwinkhart/libmutton/crypt"
+	"github.com/rwinkhart/libmutton/global"
+)
+
+// Limit is the maximum number of prior revisions kept per entry.
+// Set to 0 to disable revision history.
+var Limit = 10
+
+// Save stores the current (encrypted) contents of the entry at vanityPath
+// as a revision, named after the time it was saved (UNIX nanoseconds).
+// It should be called before an entry is overwritten.
+// The oldest revisions are removed to stay within Limit.
+// Nothing is saved if the entry does not exist yet.
+func Save(vanityPath string) error {
+	if Limit < 1 {
+		return nil
+	}
+	realPath := global.GetRealPath(vanityPath)
+	fileInfo, err := os.Stat(realPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return errors.New("unable to read mod time for " + vanityPath + ": " + err.Error())
+	}
+	if fileInfo.IsDir() || fileInfo.Size() == 0 {
+		return nil
+	}
+	encBytes, err := os.ReadFile(realPath)
+	if err != nil {
+		return errors.New("unable to read " + vanityPath + ": " + err.Error())
+	}
+
+	histPath := global.GetRealHistPath(vanityPath)
+	if err = os.MkdirAll(histPath, 0700); err != nil {
+		return errors.New("unable to create revision history directory for " + vanityPath + ": " + err.Error())
+	}
+	if err = writeRevision(histPath, encBytes); err != nil {
+		return errors.New("unable to save revision of " + vanityPath + ": " + err.Error())
+	}
+
+	// remove the oldest revisions beyond the limit
+	timestamps, err := List(vanityPath)
+	if err != nil {
+		return err
+	}
+	for _, timestamp := range timestamps[min(Limit, len(timestamps)):] {
+		if err = os.Remove(histPath + global.PathSeparator + strconv.FormatInt(timestamp, 10)); err != nil {
+			return errors.New("unable to remove old revision of " + vanityPath + ": " + err.Error())
+		}
+	}
+	return nil
+}
+
+// writeRevision writes encBytes to a new revision file in histPath.
+// If a revision with the current timestamp already exists,
+// the timestamp is incremented until it is unique.
+func writeRevision(histPath string, encBytes []byte) error {
+	timestamp := time.Now().UnixNano()
+	for {
+		f, err := os.OpenFile(histPath+global.PathSeparator+strconv.FormatInt(timestamp, 10), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+		if err != nil {
+			if os.IsExist(err) {
+				timestamp++
+				continue
+			}
+			return err
+		}
+		if _, err = f.Write(encBytes); err != nil {
+			_ = f.Close()
+			return err
+		}
+		return f.Close()
+	}
+}
+
+// List returns the timestamps (UNIX nanoseconds) of all
+// stored revisions of the entry at vanityPath, newest first.
+func List(vanityPath string) ([]int64, error) {
+	revisionList, err := os.ReadDir(global.GetRealHistPath(vanityPath))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, errors.New("unable to read revision history for " + vanityPath + ": " + err.Error())
+	}
+	timestamps := make([]int64, 0, len(revisionList))
+	for _, revision := range revisionList {
+		timestamp, err := strconv.ParseInt(revision.Name(), 10, 64)
+		if err != nil {
+			continue // ignore foreign files
+		}
+		timestamps = append(timestamps, timestamp)
+	}
+	slices.Sort(timestamps)
+	slices.Reverse(timestamps)
+	return timestamps, nil
+}
+
+// Decrypt decrypts and returns the revision of the entry at
+// vanityPath identified by timestamp (UNIX nanoseconds).
+// Leave rcwPassword nil to use RCW demonization.
+func Decrypt(vanityPath string, timestamp int64, rcwPassword []byte) ([]string, error) {
+	decSlice, err := crypt.DecryptFileToSlice(global.GetRealHistPath(vanityPath)+global.PathSeparator+strconv.FormatInt(timestamp, 10), rcwPassword)
+	if err != nil {
+		return nil, errors.New("unable to decrypt revision: " + err.Error())
+	}
+	return decSlice, nil
+}
+
+// getHistNames returns the names of all revision history directories belonging to the
+// target at vanityPath (the entry itself, its attachments, or all entries within it if it is a folder).
+func getHistNames(vanityPath string) ([]string, error) {
+	histList, err := os.ReadDir(global.HistDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, errors.New("unable to read revision history directory: " + err.Error())
+	}
+	targetName := strings.ReplaceAll(strings.TrimRight(vanityPath, "/"), "/", global.FSPath)
+	var histNames []string
+	for _, hist := range histList {
+		if hist.Name() == targetName || strings.HasPrefix(hist.Name(), targetName+global.FSPath) || strings.HasPrefix(hist.Name(), targetName+global.FSMisc) {
+			histNames = append(histNames, hist.Name())
+		}
+	}
+	return histNames, nil
+}
+
+// Remove removes the revision history of the target at vanityPath (see getHistNames).
+// It should be called when the target is sheared.
+func Remove(vanityPath string) error {
+	histNames, err := getHistNames(vanityPath)
+	if err != nil {
+		return err
+	}
+	for _, histName := range histNames {
+		if err = os.RemoveAll(global.HistDir + global.PathSeparator + histName); err != nil {
+			return errors.New("unable to remove revision history for " + strings.ReplaceAll(histName, global.FSPath, "/") + ": " + err.Error())
+		}
+	}
+	return nil
+}
+
+// Rename moves the revision history of the target at oldVanityPath (see getHistNames) to newVanityPath.
+// It should be called when the target is renamed.
+// Any existing revision history at newVanityPath is replaced.
+func Rename(oldVanityPath, newVanityPath string) error {
+	histNames, err := getHistNames(oldVanityPath)
+	if err != nil {
+		return err
+	}
+	oldName := strings.ReplaceAll(strings.TrimRight(oldVanityPath, "/"), "/", global.FSPath)
+	newName := strings.ReplaceAll(strings.TrimRight(newVanityPath, "/"), "/", global.FSPath)
+	for _, histName := range histNames {
+		newHistPath := global.HistDir + global.PathSeparator + newName + histName[len(oldName):]
+		if err = os.RemoveAll(newHistPath); err != nil {
+			return errors.New("unable to remove revision history for " + strings.ReplaceAll(newHistPath[len(global.HistDir)+1:], global.FSPath, "/") + ": " + err.Error())
+		}
+		if err = os.Rename(global.HistDir+global.PathSeparator+histName, newHistPath); err != nil {
+			return errors.New("unable to move revision history for " + strings.ReplaceAll(histName, global.FSPath, "/") + ": " + err.Error())
+		}
+	}
+	return nil
+}
+
+// GetReencryptJobs returns the jobs (see crypt.Reencrypt) needed to re-encrypt all stored revisions.
+// Output is written to global.HistDir+"-new" (the directory structure of which is created here), which must
+// be swapped in by the caller (see core.EntryRefresh).
+// Returns: the jobs and the directories they write to.
+func GetReencryptJobs() ([]crypt.ReencryptJobT, []string, error) {
+	newHistDir := global.HistDir + "-new"
+	if err := os.MkdirAll(newHistDir, 0700); err != nil {
+		return nil, nil, errors.New("unable to create temporary directory \"" + newHistDir + "\": " + err.Error())
+	}
+
+	histList, err := os.ReadDir(global.HistDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil, nil
+		}
+		return nil, nil, errors.New("unable to read revision history directory: " + err.Error())
+	}
+	var jobs []crypt.ReencryptJobT
+	var dstDirs []string
+	for _, hist := range histList {
+		revisionList, err := os.ReadDir(global.HistDir + global.PathSeparator + hist.Name())
+		if err != nil {
+			return nil, nil, errors.New("unable to read revision history for " + strings.ReplaceAll(hist.Name(), global.FSPath, "/") + ": " + err.Error())
+		}
+		dstDir := newHistDir + global.PathSeparator + hist.Name()
+		if err = os.MkdirAll(dstDir, 0700); err != nil {
+			return nil, nil, errors.New("unable to create temporary directory: " + err.Error())
+		}
+		dstDirs = append(dstDirs, dstDir)
+		for _, revision := range revisionList {
+			revisionPath := global.PathSeparator + hist.Name() + global.PathSeparator + revision.Name()
+			jobs = append(jobs, crypt.ReencryptJobT{SrcPath: global.HistDir + revisionPath, DstPath: newHistDir + revisionPath})
+		}
+	}
+	return jobs, dstDirs, nil
+}
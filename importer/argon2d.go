@@ -0,0 +1,187 @@
+package importer
+
+import (
+	"encoding/binary"
+	"math/bits"
+
+	"golang.org/x/crypto/blake2b"
+)
+
+// golang.org/x/crypto/argon2 only exposes Argon2i and Argon2id, but
+// Argon2d is the default KDF for KDBX 4 databases created by KeePass(XC).
+// The following is a minimal (single-threaded) implementation of Argon2d
+// version 0x13, as specified in RFC 9106.
+
+const argon2SyncPoints = 4 // number of slices per lane
+
+type argon2BlockT [128]uint64
+
+// argon2dKey derives a key of keyLen bytes from password and salt using Argon2d.
+// memory is in KiB.
+func argon2dKey(password, salt []byte, time, memory uint32, threads uint8, keyLen uint32) []byte {
+	return argon2d(password, salt, nil, nil, time, memory, threads, keyLen)
+}
+
+// argon2d is argon2dKey with an optional secret and associated data (unused by KDBX).
+func argon2d(password, salt, secret, data []byte, time, memory uint32, threads uint8, keyLen uint32) []byte {
+	if time < 1 {
+		time = 1
+	}
+	if threads < 1 {
+		threads = 1
+	}
+	lanes := uint32(threads)
+
+	// H0
+	h0, _ := blake2b.New512(nil)
+	for _, value := range []uint32{lanes, keyLen, memory, time, 0x13, 0} { // 0 = Argon2d
+		_ = binary.Write(h0, binary.LittleEndian, value)
+	}
+	for _, value := range [][]byte{password, salt, secret, data} {
+		_ = binary.Write(h0, binary.LittleEndian, uint32(len(value)))
+		h0.Write(value)
+	}
+	seed := h0.Sum(nil)
+
+	// round memory down to a multiple of 4*lanes blocks
+	memory = max(memory, 2*argon2SyncPoints*lanes)
+	memory -= memory % (argon2SyncPoints * lanes)
+	laneLen := memory / lanes
+	segmentLen := laneLen / argon2SyncPoints
+	blocks := make([]argon2BlockT, memory)
+
+	// initialize the first two blocks of each lane
+	buffer := make([]byte, 1024)
+	for lane := uint32(0); lane < lanes; lane++ {
+		for i := uint32(0); i < 2; i++ {
+			argon2Hash(buffer, binary.LittleEndian.AppendUint32(binary.LittleEndian.AppendUint32(seed[:64:64], i), lane))
+			for j := range blocks[lane*laneLen+i] {
+				blocks[lane*laneLen+i][j] = binary.LittleEndian.Uint64(buffer[j*8:])
+			}
+		}
+	}
+
+	// fill memory
+	for pass := uint32(0); pass < time; pass++ {
+		for slice := uint32(0); slice < argon2SyncPoints; slice++ {
+			for lane := uint32(0); lane < lanes; lane++ {
+				index := uint32(0)
+				if pass == 0 && slice == 0 {
+					index = 2
+				}
+				offset := lane*laneLen + slice*segmentLen + index
+				for ; index < segmentLen; index, offset = index+1, offset+1 {
+					prev := offset - 1
+					if index == 0 && slice == 0 {
+						prev += laneLen // wrap to the last block in the lane
+					}
+					random := blocks[prev][0]
+
+					// determine reference block (data-dependent)
+					refLane := uint32(random>>32) % lanes
+					if pass == 0 && slice == 0 {
+						refLane = lane
+					}
+					area, start := 3*segmentLen, ((slice+1)%argon2SyncPoints)*segmentLen
+					if refLane == lane {
+						area += index
+					}
+					if pass == 0 {
+						area, start = slice*segmentLen, 0
+						if slice == 0 || refLane == lane {
+							area += index
+						}
+					}
+					if index == 0 || refLane == lane {
+						area--
+					}
+					relative := random & 0xFFFFFFFF
+					relative = relative * relative >> 32
+					relative = uint64(area) * relative >> 32
+					ref := refLane*laneLen + uint32((uint64(start)+uint64(area)-(relative+1))%uint64(laneLen))
+
+					argon2Compress(&blocks[offset], &blocks[prev], &blocks[ref], pass > 0)
+				}
+			}
+		}
+	}
+
+	// finalize
+	final := blocks[laneLen-1]
+	for lane := uint32(1); lane < lanes; lane++ {
+		for i, value := range blocks[lane*laneLen+laneLen-1] {
+			final[i] ^= value
+		}
+	}
+	for i, value := range final {
+		binary.LittleEndian.PutUint64(buffer[i*8:], value)
+	}
+	key := make([]byte, keyLen)
+	argon2Hash(key, buffer)
+	return key
+}
+
+// argon2Hash is the variable-length hash function H' (fills out).
+func argon2Hash(out, in []byte) {
+	prefixed := binary.LittleEndian.AppendUint32(nil, uint32(len(out)))
+	if len(out) <= blake2b.Size {
+		h, _ := blake2b.New(len(out), nil)
+		h.Write(prefixed)
+		h.Write(in)
+		h.Sum(out[:0])
+		return
+	}
+	v := blake2b.Sum512(append(prefixed, in...))
+	for len(out) > blake2b.Size {
+		copy(out, v[:32])
+		out = out[32:]
+		if len(out) > blake2b.Size {
+			v = blake2b.Sum512(v[:])
+		}
+	}
+	h, _ := blake2b.New(len(out), nil)
+	h.Write(v[:])
+	h.Sum(out[:0])
+}
+
+// argon2Compress computes the compression function G(x, y) and
+// stores (or, if xor is true, XORs) the result in out.
+func argon2Compress(out, x, y *argon2BlockT, xor bool) {
+	var r, z argon2BlockT
+	for i := range r {
+		r[i] = x[i] ^ y[i]
+	}
+	z = r
+	// apply the permutation to each row, then to each column
+	for i := 0; i < 128; i += 16 {
+		argon2Permute(&z, [16]int{i, i + 1, i + 2, i + 3, i + 4, i + 5, i + 6, i + 7, i + 8, i + 9, i + 10, i + 11, i + 12, i + 13, i + 14, i + 15})
+	}
+	for i := 0; i < 16; i += 2 {
+		argon2Permute(&z, [16]int{i, i + 1, i + 16, i + 17, i + 32, i + 33, i + 48, i + 49, i + 64, i + 65, i + 80, i + 81, i + 96, i + 97, i + 112, i + 113})
+	}
+	for i := range out {
+		if xor {
+			out[i] ^= r[i] ^ z[i]
+		} else {
+			out[i] = r[i] ^ z[i]
+		}
+	}
+}
+
+// argon2Permute applies the BlaMka-based permutation P to the words of b at the given indices.
+func argon2Permute(b *argon2BlockT, idx [16]int) {
+	for _, quarter := range [8][4]int{
+		{0, 4, 8, 12}, {1, 5, 9, 13}, {2, 6, 10, 14}, {3, 7, 11, 15},
+		{0, 5, 10, 15}, {1, 6, 11, 12}, {2, 7, 8, 13}, {3, 4, 9, 14},
+	} {
+		a, bb, c, d := &b[idx[quarter[0]]], &b[idx[quarter[1]]], &b[idx[quarter[2]]], &b[idx[quarter[3]]]
+		*a += *bb + 2*uint64(uint32(*a))*uint64(uint32(*bb))
+		*d = bits.RotateLeft64(*d^*a, -32)
+		*c += *d + 2*uint64(uint32(*c))*uint64(uint32(*d))
+		*bb = bits.RotateLeft64(*bb^*c, -24)
+		*a += *bb + 2*uint64(uint32(*a))*uint64(uint32(*bb))
+		*d = bits.RotateLeft64(*d^*a, -16)
+		*c += *d + 2*uint64(uint32(*c))*uint64(uint32(*d))
+		*bb = bits.RotateLeft64(*bb^*c, -63)
+	}
+}
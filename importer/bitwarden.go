@@ -0,0 +1,255 @@
+package importer
+
+import (
+	"crypto/hkdf"
+	"crypto/hmac"
+	"crypto/pbkdf2"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/rwinkhart/libmutton/core"
+	"golang.org/x/crypto/argon2"
+)
+
+// KDF parameter limits for password-protected exports (far above the defaults of any Bitwarden client);
+// exports exceeding them are rejected rather than exhausting memory or time
+const (
+	bitwardenMaxPBKDF2Iterations = 10000000
+	bitwardenMaxArgon2Memory     = 4096 // MiB (4 GiB)
+	bitwardenMaxArgon2Iterations = 1000
+)
+
+// bitwardenExportT is the top-level structure of a Bitwarden JSON export.
+type bitwardenExportT struct {
+	Encrypted         bool   `json:"encrypted"`
+	PasswordProtected bool   `json:"passwordProtected"`
+	Salt              string `json:"salt"`
+	KDFType           int    `json:"kdfType"` // 0 = PBKDF2-SHA256, 1 = Argon2id
+	KDFIterations     int    `json:"kdfIterations"`
+	KDFMemory         int    `json:"kdfMemory"` // MiB
+	KDFParallelism    int    `json:"kdfParallelism"`
+	KeyValidation     string `json:"encKeyValidation_DO_NOT_EDIT"`
+	Data              string `json:"data"`
+
+	Folders []struct {
+		ID   string `json:"id"`
+		Name string `json:"name"`
+	} `json:"folders"`
+	Collections []struct {
+		ID   string `json:"id"`
+		Name string `json:"name"`
+	} `json:"collections"`
+	Items []bitwardenItemT `json:"items"`
+}
+
+// bitwardenItemT is a single item in a Bitwarden JSON export.
+type bitwardenItemT struct {
+	Type          int      `json:"type"` // 1 = login, 2 = secure note, 3 = card, 4 = identity, 5 = SSH key
+	Name          string   `json:"name"`
+	Notes         string   `json:"notes"`
+	FolderID      string   `json:"folderId"`
+	CollectionIDs []string `json:"collectionIds"`
+	Fields        []struct {
+		Name  string `json:"name"`
+		Value string `json:"value"`
+		Type  int    `json:"type"` // 0 = text, 1 = hidden, 2 = boolean, 3 = linked
+	} `json:"fields"`
+	Login *struct {
+		URIs []struct {
+			URI string `json:"uri"`
+		} `json:"uris"`
+		Username string `json:"username"`
+		Password string `json:"password"`
+		TOTP     string `json:"totp"`
+	} `json:"login"`
+	Card     map[string]any `json:"card"`
+	Identity map[string]any `json:"identity"`
+	SSHKey   map[string]any `json:"sshKey"`
+}
+
+// bitwardenHiddenFields are card/identity/SSH key properties imported as hidden custom fields.
+var bitwardenHiddenFields = map[string]bool{"number": true, "code": true, "ssn": true, "passportNumber": true, "licenseNumber": true, "privateKey": true}
+
+// Bitwarden imports all items from a Bitwarden JSON export into folderVanityPath (created if needed).
+// Password-protected exports are decrypted with exportPassword (leave nil for unencrypted exports);
+// account-restricted encrypted exports are not supported.
+// Folders (and, for organization exports, collections) are mapped to libmutton folders.
+// Card, identity, and SSH key items are imported with their properties as custom fields.
+// If dryRun is true, nothing is written (see ReportT).
+// Leave rcwPassword nil to use RCW demonization.
+func Bitwarden(exportPath string, exportPassword []byte, folderVanityPath string, dryRun bool, rcwPassword []byte) (*ReportT, error) {
+	data, err := os.ReadFile(exportPath)
+	if err != nil {
+		return nil, errors.New("unable to read export: " + err.Error())
+	}
+	var export bitwardenExportT
+	if err = json.Unmarshal(data, &export); err != nil {
+		return nil, errors.New("unable to parse export: " + err.Error())
+	}
+	if export.Encrypted {
+		if !export.PasswordProtected {
+			return nil, errors.New("account-restricted encrypted exports are not supported; export as unencrypted or password-protected JSON instead")
+		}
+		if data, err = decryptBitwardenExport(&export, exportPassword); err != nil {
+			return nil, err
+		}
+		export = bitwardenExportT{}
+		if err = json.Unmarshal(data, &export); err != nil {
+			return nil, errors.New("unable to parse decrypted export: " + err.Error())
+		}
+	}
+
+	// map folder/collection IDs to vanity paths (nested folders use "/" in their names)
+	folderPaths := map[string]string{}
+	var folders []string
+	for _, folder := range export.Folders {
+		folderPaths[folder.ID] = getBitwardenFolderPath(folder.Name)
+		folders = append(folders, folderPaths[folder.ID])
+	}
+	for _, collection := range export.Collections {
+		folderPaths[collection.ID] = getBitwardenFolderPath(collection.Name)
+		folders = append(folders, folderPaths[collection.ID])
+	}
+
+	entries := make([]importEntryT, 0, len(export.Items))
+	for _, item := range export.Items {
+		folder := folderPaths[item.FolderID]
+		if item.FolderID == "" && len(item.CollectionIDs) > 0 {
+			folder = folderPaths[item.CollectionIDs[0]]
+		}
+		entries = append(entries, importEntryT{folder: folder, name: item.Name, entry: parseBitwardenItem(&item)})
+	}
+	return importEntries(folders, entries, folderVanityPath, CollisionRename, dryRun, rcwPassword)
+}
+
+// getBitwardenFolderPath converts a Bitwarden folder name (which uses "/" for nesting) to a relative vanity path.
+func getBitwardenFolderPath(name string) string {
+	var folder string
+	for _, element := range strings.Split(name, "/") {
+		if strings.TrimSpace(element) != "" {
+			folder += "/" + sanitizeName(element)
+		}
+	}
+	return folder
+}
+
+// parseBitwardenItem converts a Bitwarden item into an EntryT.
+func parseBitwardenItem(item *bitwardenItemT) *core.EntryT {
+	entry := &core.EntryT{}
+	if item.Login != nil {
+		entry.Username = item.Login.Username
+		entry.Password = item.Login.Password
+		entry.TOTP = strings.TrimSpace(item.Login.TOTP)
+		if secret, ok := strings.CutPrefix(entry.TOTP, "steam://"); ok {
+			entry.TOTP = "steam@" + secret
+		}
+		for i, uri := range item.Login.URIs {
+			if i == 0 {
+				entry.URL = uri.URI
+			} else if uri.URI != "" {
+				addCustomField(entry, "URL "+strconv.Itoa(i+1), uri.URI, false)
+			}
+		}
+	}
+	if notes := strings.ReplaceAll(item.Notes, "\r\n", "\n"); strings.TrimSpace(notes) != "" {
+		entry.Notes = strings.Split(notes, "\n")
+	}
+	for _, properties := range []map[string]any{item.Card, item.Identity, item.SSHKey} {
+		addBitwardenProperties(entry, properties)
+	}
+	for _, field := range item.Fields {
+		if field.Type == 3 || field.Value == "" { // linked fields only reference other properties
+			continue
+		}
+		addCustomField(entry, field.Name, field.Value, field.Type == 1)
+	}
+	return entry
+}
+
+// addBitwardenProperties adds the non-empty properties of a card, identity, or
+// SSH key item to entry as custom fields (in a stable, alphabetical order).
+func addBitwardenProperties(entry *core.EntryT, properties map[string]any) {
+	keys := make([]string, 0, len(properties))
+	for key := range properties {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	for _, key := range keys {
+		if value, ok := properties[key].(string); ok && value != "" {
+			addCustomField(entry, key, value, bitwardenHiddenFields[key])
+		}
+	}
+}
+
+// decryptBitwardenExport decrypts the data of a password-protected Bitwarden export.
+func decryptBitwardenExport(export *bitwardenExportT, exportPassword []byte) ([]byte, error) {
+	// derive key
+	var key []byte
+	switch export.KDFType {
+	case 0:
+		if export.KDFIterations < 1 || export.KDFIterations > bitwardenMaxPBKDF2Iterations {
+			return nil, errors.New("unsupported PBKDF2 parameters (iterations must be 1-" + strconv.Itoa(bitwardenMaxPBKDF2Iterations) + ")")
+		}
+		var err error
+		if key, err = pbkdf2.Key(sha256.New, string(exportPassword), []byte(export.Salt), export.KDFIterations, 32); err != nil {
+			return nil, errors.New("unable to derive key: " + err.Error())
+		}
+	case 1:
+		if export.KDFIterations < 1 || export.KDFMemory < 1 || export.KDFParallelism < 1 || export.KDFParallelism > 255 {
+			return nil, errors.New("invalid Argon2 parameters")
+		}
+		if export.KDFIterations > bitwardenMaxArgon2Iterations || export.KDFMemory > bitwardenMaxArgon2Memory {
+			return nil, errors.New("unsupported Argon2 parameters (memory or iterations too high)")
+		}
+		salt := sha256.Sum256([]byte(export.Salt))
+		key = argon2.IDKey(exportPassword, salt[:], uint32(export.KDFIterations), uint32(export.KDFMemory)*1024, uint8(export.KDFParallelism), 32)
+	default:
+		return nil, errors.New("unsupported key derivation function")
+	}
+	encKey, err := hkdf.Expand(sha256.New, key, "enc", 32)
+	if err != nil {
+		return nil, errors.New("unable to derive key: " + err.Error())
+	}
+	macKey, err := hkdf.Expand(sha256.New, key, "mac", 32)
+	if err != nil {
+		return nil, errors.New("unable to derive key: " + err.Error())
+	}
+
+	// validate password, then decrypt data
+	if _, err = decryptBitwardenString(export.KeyValidation, encKey, macKey); err != nil {
+		return nil, errors.New("incorrect export password")
+	}
+	return decryptBitwardenString(export.Data, encKey, macKey)
+}
+
+// decryptBitwardenString decrypts a Bitwarden "EncString" of type 2
+// ("2.<iv>|<ciphertext>|<mac>", AES-256-CBC with HMAC-SHA256).
+func decryptBitwardenString(encString string, encKey, macKey []byte) ([]byte, error) {
+	encType, content, _ := strings.Cut(encString, ".")
+	parts := strings.Split(content, "|")
+	if encType != "2" || len(parts) != 3 {
+		return nil, errors.New("unsupported encrypted data format")
+	}
+	var decoded [3][]byte
+	for i, part := range parts {
+		var err error
+		if decoded[i], err = base64.StdEncoding.DecodeString(part); err != nil {
+			return nil, errors.New("unable to decode encrypted data: " + err.Error())
+		}
+	}
+	iv, ciphertext, mac := decoded[0], decoded[1], decoded[2]
+
+	macHash := hmac.New(sha256.New, macKey)
+	macHash.Write(iv)
+	macHash.Write(ciphertext)
+	if !hmac.Equal(macHash.Sum(nil), mac) {
+		return nil, errors.New("encrypted data failed authentication")
+	}
+	return decryptAESCBC(encKey, iv, ciphertext)
+}
@@ -0,0 +1,255 @@
+package importer
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/base32"
+	"errors"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/rwinkhart/libmutton/core"
+	"github.com/rwinkhart/libmutton/global"
+	"github.com/rwinkhart/libmutton/syncclient"
+)
+
+// sanitizeName makes name usable as a single vanity path element on all platforms.
+func sanitizeName(name string) string {
+	name = strings.Map(func(r rune) rune {
+		switch r {
+		case '/', '\\', ':', '*', '?', '"', '<', '>', '|':
+			return '-'
+		case '\r', '\n', '\t':
+			return ' '
+		}
+		return r
+	}, name)
+	for _, fsChar := range []string{global.FSSpace, global.FSPath, global.FSMisc} {
+		name = strings.ReplaceAll(name, fsChar, "")
+	}
+	name = strings.Trim(strings.TrimSpace(name), ".")
+	if name == "" {
+		return "untitled"
+	}
+	return name
+}
+
+// addMissingFolders appends the folder at vanityPath and any of its parents that do not exist
+// locally (and are not yet in pending) to missingFolders, parents first.
+func addMissingFolders(vanityPath string, missingFolders *[]string, pending map[string]bool) {
+	var partialPath string
+	for _, element := range strings.Split(strings.Trim(vanityPath, "/"), "/") {
+		if element == "" {
+			continue
+		}
+		partialPath += "/" + element
+		if pending[partialPath] {
+			continue
+		}
+		if _, err := os.Stat(global.GetRealPath(partialPath)); err == nil {
+			continue
+		}
+		pending[partialPath] = true
+		*missingFolders = append(*missingFolders, partialPath)
+	}
+}
+
+// CollisionPolicyT determines how imported entries whose vanity paths
+// are already in use (as reported by core.EntryAddPrecheck) are handled.
+type CollisionPolicyT uint8
+
+const (
+	CollisionRename    CollisionPolicyT = iota // import with the lowest free numeric suffix (e.g. "/github (2)")
+	CollisionSkip                              // do not import
+	CollisionOverwrite                         // replace the existing entry
+)
+
+// ReportT summarizes the result of an import.
+type ReportT struct {
+	Imported   []string   // vanity paths of imported entries (in dry-run mode, the vanity paths entries would be imported to)
+	Collisions []string   // vanity paths that were already in use (handled according to the collision policy)
+	Failed     []FailureT // items that could not be imported
+}
+
+// FailureT describes an item that could not be imported.
+type FailureT struct {
+	Item   string // source item (e.g. "row 5") or, if unavailable, the intended vanity path
+	Reason string
+}
+
+// importEntryT is an entry parsed from an export, ready to be imported.
+type importEntryT struct {
+	source string // identifies the entry in the export for failure reports (optional)
+	folder string // containing folder, relative to the import folder (e.g. "/work/email"; "" for the import folder itself)
+	name   string // entry name (sanitized on import)
+	entry  *core.EntryT
+	failed string // if set, the entry is reported as failed for this reason rather than imported
+}
+
+// importEntries imports parsed entries (and folders, relative to folderVanityPath) into folderVanityPath.
+// A vanity path collides if it is already in use (as reported by core.EntryAddPrecheck) or was claimed
+// by an earlier entry in the same import; collisions are handled according to policy
+// (except for collisions between entries in the same import, which are always renamed).
+// Entries that cannot be imported (including empty entries) are reported rather than aborting the import.
+// If dryRun is true, nothing is written; the report describes what would have been imported.
+// Leave rcwPassword nil to use RCW demonization.
+func importEntries(folders []string, entries []importEntryT, folderVanityPath string, policy CollisionPolicyT, dryRun bool, rcwPassword []byte) (*ReportT, error) {
+	folderVanityPath = strings.TrimRight(folderVanityPath, "/")
+	report := &ReportT{}
+
+	// create folders (locally and remotely, so that empty folders reach other devices)
+	if !dryRun {
+		var missingFolders []string
+		pending := map[string]bool{}
+		addMissingFolders(folderVanityPath, &missingFolders, pending)
+		for _, folder := range folders {
+			addMissingFolders(folderVanityPath+folder, &missingFolders, pending)
+		}
+		for _, parsed := range entries {
+			if parsed.failed == "" {
+				addMissingFolders(folderVanityPath+parsed.folder, &missingFolders, pending)
+			}
+		}
+		if err := syncclient.AddFoldersRemote(missingFolders); err != nil {
+			return report, errors.New("unable to create folder: " + err.Error())
+		}
+	}
+
+	claimed := map[string]bool{}
+	var staleAgeFiles []string
+	for _, parsed := range entries {
+		vanityPath := folderVanityPath + parsed.folder + "/" + sanitizeName(parsed.name)
+		fail := func(reason string) {
+			report.Failed = append(report.Failed, FailureT{Item: cmpOr(parsed.source, vanityPath), Reason: reason})
+		}
+		if parsed.failed != "" {
+			fail(parsed.failed)
+			continue
+		}
+		if !core.EntryIsNotEmpty(core.SerializeEntry(parsed.entry)) {
+			fail("entry is empty")
+			continue
+		}
+
+		// check for collisions
+		statusCode, err := core.EntryAddPrecheck(global.GetRealPath(vanityPath))
+		if statusCode == 2 && !dryRun { // in dry-run mode, the containing folder may not exist yet
+			fail(err.Error())
+			continue
+		}
+		var ageIsStale bool // whether an overwritten entry's age file must be removed
+		if statusCode == 1 || claimed[vanityPath] {
+			report.Collisions = append(report.Collisions, vanityPath)
+			// entries from the same import are always renamed, as overwriting (or skipping) them would silently lose data
+			switch {
+			case claimed[vanityPath] || policy == CollisionRename:
+				base := vanityPath
+				for i := 2; ; i++ {
+					vanityPath = base + " (" + strconv.Itoa(i) + ")"
+					if statusCode, _ = core.EntryAddPrecheck(global.GetRealPath(vanityPath)); statusCode != 1 && !claimed[vanityPath] {
+						break
+					}
+				}
+			case policy == CollisionSkip:
+				continue
+			default:
+				// the replaced entry's password age no longer applies if the new entry has no password
+				ageIsStale = parsed.entry.Password == ""
+			}
+		}
+		claimed[vanityPath] = true
+
+		if !dryRun {
+			if info, err := os.Stat(global.GetRealPath(vanityPath)); err == nil && info.IsDir() {
+				fail("a folder already exists at " + vanityPath)
+				continue
+			}
+			// clone rcwPassword, as it is zeroized after use (nil is preserved for RCW demonization)
+			if err = core.WriteParsedEntry(global.GetRealPath(vanityPath), parsed.entry, parsed.entry.Password != "", bytes.Clone(rcwPassword)); err != nil {
+				fail("unable to write entry: " + err.Error())
+				continue
+			}
+			if _, err = os.Stat(global.GetRealAgePath(vanityPath)); ageIsStale && err == nil {
+				staleAgeFiles = append(staleAgeFiles, vanityPath)
+			}
+		}
+		report.Imported = append(report.Imported, vanityPath)
+	}
+
+	// remove the age files of overwritten entries that no longer have a password
+	if !dryRun && len(staleAgeFiles) > 0 {
+		if err := syncclient.ShearTargetsRemote(staleAgeFiles, true); err != nil {
+			return report, errors.New("unable to remove age data: " + err.Error())
+		}
+	}
+	return report, nil
+}
+
+// base32NoPadding is the encoding used for secrets in "otpauth://" URIs.
+var base32NoPadding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// cmpOr returns the first non-empty string.
+func cmpOr(a, b string) string {
+	if a != "" {
+		return a
+	}
+	return b
+}
+
+// formOTPAuthURI forms an "otpauth://" URI of otpType ("totp" or "hotp")
+// labeled with issuer and accountName (either may be empty).
+// query must contain at least the "secret" parameter.
+func formOTPAuthURI(otpType, issuer, accountName string, query url.Values) string {
+	label := accountName
+	if issuer != "" {
+		if query.Get("issuer") == "" {
+			query.Set("issuer", issuer)
+		}
+		label = issuer
+		if accountName != "" {
+			label += ":" + accountName
+		}
+	}
+	return (&url.URL{Scheme: "otpauth", Host: otpType, Path: "/" + label, RawQuery: query.Encode()}).String()
+}
+
+// addCustomField adds a field without a standard libmutton equivalent to entry.
+// Duplicate names are given a numeric suffix (e.g. "URL (2)").
+// Values spanning multiple lines cannot be stored as custom fields,
+// so they are appended to the notes (prefixed with the field name) instead.
+func addCustomField(entry *core.EntryT, name, value string, hidden bool) {
+	name = strings.NewReplacer(global.FSSpace, "", "\r", " ", "\n", " ").Replace(name)
+	if strings.TrimSpace(name) == "" {
+		name = "field"
+	}
+	// avoid replacing existing fields with the same name
+	uniqueName := name
+	for i := 2; core.GetCustomField(entry, uniqueName) != nil; i++ {
+		uniqueName = name + " (" + strconv.Itoa(i) + ")"
+	}
+	if core.SetCustomField(entry, uniqueName, value, hidden) != nil {
+		entry.Notes = append(entry.Notes, name+":")
+		entry.Notes = append(entry.Notes, strings.Split(strings.ReplaceAll(value, "\r\n", "\n"), "\n")...)
+	}
+}
+
+// decryptAESCBC decrypts PKCS#7-padded AES-256-CBC ciphertext.
+func decryptAESCBC(key, iv, ciphertext []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(iv) != aes.BlockSize || len(ciphertext) == 0 || len(ciphertext)%aes.BlockSize != 0 {
+		return nil, errors.New("ciphertext is malformed")
+	}
+	plaintext := make([]byte, len(ciphertext))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(plaintext, ciphertext)
+	padding := int(plaintext[len(plaintext)-1])
+	if padding < 1 || padding > aes.BlockSize {
+		return nil, errors.New("ciphertext is malformed (invalid padding)")
+	}
+	return plaintext[:len(plaintext)-padding], nil
+}
@@ -0,0 +1,152 @@
+package importer
+
+import (
+	"encoding/csv"
+	"errors"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/rwinkhart/libmutton/core"
+)
+
+// CSVMappingT maps CSV columns to entry fields.
+// Column numbers are 1-based; leave a column at 0 if it is not present in the CSV.
+type CSVMappingT struct {
+	Header bool // the first row is a header (used to name custom fields) rather than an entry
+	Comma  rune // field delimiter (defaults to ',')
+
+	Path     int // entry name, optionally including folders (e.g. "work/github"); required
+	Folder   int // containing folder (e.g. "work/email"), prepended to Path
+	Password int
+	Username int
+	TOTP     int
+	URL      int
+	Notes    int   // multi-line values are split into multiple note lines
+	Custom   []int // stored as custom fields, named after the header (or "column N" if there is no header)
+	Hidden   []int // stored as hidden custom fields (named as above)
+}
+
+// CSV imports all rows of a CSV file into folderVanityPath (created if needed), as described by mapping.
+// Entries whose vanity paths are already in use are handled according to policy.
+// Every row that could not be imported (e.g. malformed rows, rows without a
+// name, empty rows, or rows that failed to be written) is included in the report.
+// If dryRun is true, nothing is written (see ReportT).
+// Leave rcwPassword nil to use RCW demonization.
+func CSV(csvPath string, mapping *CSVMappingT, folderVanityPath string, policy CollisionPolicyT, dryRun bool, rcwPassword []byte) (*ReportT, error) {
+	if mapping.Path < 1 {
+		return nil, errors.New("CSV mapping must include the path column")
+	}
+	f, err := os.Open(csvPath)
+	if err != nil {
+		return nil, errors.New("unable to open CSV file: " + err.Error())
+	}
+	defer func(f *os.File) {
+		_ = f.Close()
+	}(f)
+	reader := csv.NewReader(f)
+	reader.FieldsPerRecord = -1 // row lengths are validated against the mapping instead
+	if mapping.Comma != 0 {
+		reader.Comma = mapping.Comma
+	}
+
+	var header []string
+	var entries []importEntryT
+	var failed []FailureT
+	for rowNum := 1; ; rowNum++ {
+		row, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		source := "row " + strconv.Itoa(rowNum)
+		if err != nil {
+			var parseErr *csv.ParseError
+			if !errors.As(err, &parseErr) {
+				return nil, errors.New("unable to read CSV file: " + err.Error())
+			}
+			failed = append(failed, FailureT{Item: source, Reason: parseErr.Err.Error()})
+			continue
+		}
+		if rowNum == 1 && mapping.Header {
+			header = row
+			continue
+		}
+
+		parsed, err := parseCSVRow(row, header, mapping)
+		if err != nil {
+			failed = append(failed, FailureT{Item: source, Reason: err.Error()})
+			continue
+		}
+		parsed.source = source
+		entries = append(entries, parsed)
+	}
+
+	report, err := importEntries(nil, entries, folderVanityPath, policy, dryRun, rcwPassword)
+	if report != nil {
+		report.Failed = append(failed, report.Failed...)
+	}
+	return report, err
+}
+
+// parseCSVRow converts a CSV row into an entry as described by mapping.
+func parseCSVRow(row, header []string, mapping *CSVMappingT) (importEntryT, error) {
+	// getColumn returns the value of a (1-based) column (recording the column if the row is too short)
+	var missingColumn int
+	getColumn := func(column int) string {
+		if column < 1 {
+			return ""
+		}
+		if column > len(row) {
+			missingColumn = column
+			return ""
+		}
+		return row[column-1]
+	}
+
+	var parsed importEntryT
+	path := getColumn(mapping.Path)
+	if missingColumn != 0 {
+		return parsed, errors.New("row is missing column " + strconv.Itoa(missingColumn))
+	}
+	if strings.Trim(path, "/ ") == "" {
+		return parsed, errors.New("row has no entry name")
+	}
+	for _, element := range strings.Split(getColumn(mapping.Folder)+"/"+path, "/") {
+		if strings.TrimSpace(element) != "" {
+			parsed.folder += "/" + sanitizeName(element)
+		}
+	}
+	// the last path element is the entry name
+	nameIndex := strings.LastIndex(parsed.folder, "/")
+	parsed.folder, parsed.name = parsed.folder[:nameIndex], parsed.folder[nameIndex+1:]
+
+	parsed.entry = &core.EntryT{
+		Password: getColumn(mapping.Password),
+		Username: getColumn(mapping.Username),
+		TOTP:     strings.TrimSpace(getColumn(mapping.TOTP)),
+		URL:      getColumn(mapping.URL),
+	}
+	if notes := strings.ReplaceAll(getColumn(mapping.Notes), "\r\n", "\n"); strings.TrimSpace(notes) != "" {
+		parsed.entry.Notes = strings.Split(notes, "\n")
+	}
+	for _, hidden := range []bool{false, true} {
+		columns := mapping.Custom
+		if hidden {
+			columns = mapping.Hidden
+		}
+		for _, column := range columns {
+			if value := getColumn(column); value != "" {
+				name := "column " + strconv.Itoa(column)
+				if column <= len(header) && strings.TrimSpace(header[column-1]) != "" {
+					name = strings.TrimSpace(header[column-1])
+				}
+				addCustomField(parsed.entry, name, value, hidden)
+			}
+		}
+	}
+	if missingColumn != 0 {
+		return parsed, errors.New("row is missing column " + strconv.Itoa(missingColumn))
+	}
+	return parsed, nil
+}
@@ -0,0 +1,189 @@
+package importer
+
+import (
+	"encoding/base64"
+	"errors"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/rwinkhart/libmutton/core"
+)
+
+// gAuthAccountT is a single account from a Google Authenticator migration payload.
+type gAuthAccountT struct {
+	secret    []byte
+	name      string
+	issuer    string
+	algorithm uint64 // 0 = unspecified, 1 = SHA1, 2 = SHA256, 3 = SHA512, 4 = MD5
+	digits    uint64 // 0 = unspecified, 1 = six, 2 = eight
+	otpType   uint64 // 0 = unspecified, 1 = HOTP, 2 = TOTP
+	counter   uint64
+}
+
+// GoogleAuthenticator imports all accounts from a Google Authenticator
+// export ("otpauth-migration://offline?data=...") into folderVanityPath
+// (created if needed), creating one entry per account.
+// Each entry is named after the account's issuer (falling back to the account name),
+// with the account name as the username and an "otpauth://" URI as the TOTP secret.
+// Exports split across multiple QR codes require one call per code.
+// If dryRun is true, nothing is written (see ReportT).
+// Leave rcwPassword nil to use RCW demonization.
+func GoogleAuthenticator(migrationURI, folderVanityPath string, dryRun bool, rcwPassword []byte) (*ReportT, error) {
+	accounts, err := parseGAuthMigration(migrationURI)
+	if err != nil {
+		return nil, err
+	}
+	var entries []importEntryT
+	for _, account := range accounts {
+		// Google Authenticator stores "issuer:name" in the name field for some accounts
+		name := account.name
+		if account.issuer != "" {
+			name = strings.TrimPrefix(name, account.issuer+":")
+		}
+		if len(account.secret) == 0 {
+			entries = append(entries, importEntryT{name: cmpOr(account.issuer, name), failed: "account has no secret"})
+			continue
+		}
+		entries = append(entries, importEntryT{
+			name:  cmpOr(account.issuer, name),
+			entry: &core.EntryT{Username: name, TOTP: formGAuthURI(account, name)},
+		})
+	}
+	return importEntries(nil, entries, folderVanityPath, CollisionRename, dryRun, rcwPassword)
+}
+
+// formGAuthURI forms an "otpauth://" URI for a Google Authenticator account.
+func formGAuthURI(account gAuthAccountT, name string) string {
+	query := url.Values{}
+	query.Set("secret", base32NoPadding.EncodeToString(account.secret))
+	switch account.algorithm {
+	case 2:
+		query.Set("algorithm", "SHA256")
+	case 3:
+		query.Set("algorithm", "SHA512")
+	case 4:
+		query.Set("algorithm", "MD5")
+	}
+	if account.digits == 2 {
+		query.Set("digits", "8")
+	}
+	if account.otpType == 1 {
+		query.Set("counter", strconv.FormatUint(account.counter, 10))
+		return formOTPAuthURI("hotp", account.issuer, name, query)
+	}
+	return formOTPAuthURI("totp", account.issuer, name, query)
+}
+
+// parseGAuthMigration decodes the protobuf payload of a Google Authenticator migration URI.
+func parseGAuthMigration(migrationURI string) ([]gAuthAccountT, error) {
+	u, err := url.Parse(strings.TrimSpace(migrationURI))
+	if err != nil || u.Scheme != "otpauth-migration" {
+		return nil, errors.New("not a Google Authenticator migration URI")
+	}
+	// "+" may be left unescaped by some QR scanners, in which case it is decoded as a space
+	data := strings.ReplaceAll(u.Query().Get("data"), " ", "+")
+	payload, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		if payload, err = base64.RawStdEncoding.DecodeString(strings.TrimRight(data, "=")); err != nil {
+			return nil, errors.New("unable to decode migration payload: " + err.Error())
+		}
+	}
+
+	var accounts []gAuthAccountT
+	err = readProtobuf(payload, func(fieldNum uint64, varint uint64, data []byte) error {
+		if fieldNum != 1 || data == nil { // repeated OtpParameters otp_parameters = 1
+			return nil
+		}
+		var account gAuthAccountT
+		err := readProtobuf(data, func(fieldNum uint64, varint uint64, data []byte) error {
+			switch fieldNum {
+			case 1:
+				account.secret = data
+			case 2:
+				account.name = string(data)
+			case 3:
+				account.issuer = string(data)
+			case 4:
+				account.algorithm = varint
+			case 5:
+				account.digits = varint
+			case 6:
+				account.otpType = varint
+			case 7:
+				account.counter = varint
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		accounts = append(accounts, account)
+		return nil
+	})
+	if err != nil {
+		return nil, errors.New("unable to decode migration payload: " + err.Error())
+	}
+	return accounts, nil
+}
+
+// readProtobuf iterates over the fields of a protobuf message, calling fieldCB
+// with the field number and either its varint value or its length-delimited data
+// (data is nil for varint fields). Fixed-size fields are skipped.
+func readProtobuf(message []byte, fieldCB func(fieldNum uint64, varint uint64, data []byte) error) error {
+	for len(message) > 0 {
+		key, n := readVarint(message)
+		if n == 0 {
+			return errors.New("malformed field key")
+		}
+		message = message[n:]
+		fieldNum, wireType := key>>3, key&7
+		switch wireType {
+		case 0: // varint
+			value, n := readVarint(message)
+			if n == 0 {
+				return errors.New("malformed varint")
+			}
+			message = message[n:]
+			if err := fieldCB(fieldNum, value, nil); err != nil {
+				return err
+			}
+		case 1: // 64-bit
+			if len(message) < 8 {
+				return errors.New("truncated message")
+			}
+			message = message[8:]
+		case 2: // length-delimited
+			length, n := readVarint(message)
+			if n == 0 || uint64(len(message)-n) < length {
+				return errors.New("truncated message")
+			}
+			data := message[n : n+int(length)]
+			message = message[n+int(length):]
+			if err := fieldCB(fieldNum, 0, append([]byte{}, data...)); err != nil {
+				return err
+			}
+		case 5: // 32-bit
+			if len(message) < 4 {
+				return errors.New("truncated message")
+			}
+			message = message[4:]
+		default:
+			return errors.New("unsupported wire type " + strconv.FormatUint(wireType, 10))
+		}
+	}
+	return nil
+}
+
+// readVarint decodes a protobuf varint.
+// Returns: the value, the number of bytes read (0 if malformed).
+func readVarint(buf []byte) (uint64, int) {
+	var value uint64
+	for i := 0; i < len(buf) && i < 10; i++ {
+		value |= uint64(buf[i]&0x7f) << (7 * i)
+		if buf[i] < 0x80 {
+			return value, i + 1
+		}
+	}
+	return 0, 0
+}
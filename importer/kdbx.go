@@ -0,0 +1,555 @@
+package importer
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/aes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"io"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/rwinkhart/libmutton/core"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/chacha20"
+)
+
+// KDBX 4 header field IDs
+const (
+	kdbxHeaderEnd         = 0
+	kdbxHeaderCipherID    = 2
+	kdbxHeaderCompression = 3
+	kdbxHeaderMasterSeed  = 4
+	kdbxHeaderIV          = 7
+	kdbxHeaderKDFParams   = 11
+
+	kdbxInnerHeaderEnd       = 0
+	kdbxInnerHeaderStreamID  = 1
+	kdbxInnerHeaderStreamKey = 2
+
+	kdbxInnerStreamChaCha20 = 3
+)
+
+// KDF parameter limits (far above the defaults of any KeePass client);
+// databases exceeding them are rejected rather than exhausting memory or time
+const (
+	kdbxMaxArgon2Memory     = 4 << 20 // KiB (4 GiB)
+	kdbxMaxArgon2Iterations = 1000
+	kdbxMaxAESRounds        = 1 << 30
+	kdbxMaxPayloadSize      = 256 << 20 // bytes (decompressed)
+)
+
+// KDBX 4 cipher and KDF UUIDs
+var (
+	kdbxCipherAES256   = []byte{0x31, 0xc1, 0xf2, 0xe6, 0xbf, 0x71, 0x43, 0x50, 0xbe, 0x58, 0x05, 0x21, 0x6a, 0xfc, 0x5a, 0xff}
+	kdbxCipherChaCha20 = []byte{0xd6, 0x03, 0x8a, 0x2b, 0x8b, 0x6f, 0x4c, 0xb5, 0xa5, 0x24, 0x33, 0x9a, 0x31, 0xdb, 0xb5, 0x9a}
+	kdbxKDFAES         = []byte{0xc9, 0xd9, 0xf3, 0x9a, 0x62, 0x8a, 0x44, 0x60, 0xbf, 0x74, 0x0d, 0x08, 0xc1, 0x8a, 0x4f, 0xea}
+	kdbxKDFAESLegacy   = []byte{0x7c, 0x02, 0xbb, 0x82, 0x79, 0xa7, 0x4a, 0xc0, 0x92, 0x7d, 0x11, 0x4a, 0x00, 0x64, 0x82, 0x38}
+	kdbxKDFArgon2d     = []byte{0xef, 0x63, 0x6d, 0xdf, 0x8c, 0x29, 0x44, 0x4b, 0x91, 0xf7, 0xa9, 0xa4, 0x03, 0xe3, 0x0a, 0x0c}
+	kdbxKDFArgon2id    = []byte{0x9e, 0x29, 0x8b, 0x19, 0x56, 0xdb, 0x47, 0x73, 0xb2, 0x3d, 0xfc, 0x3e, 0xc6, 0xf0, 0xa1, 0xe6}
+)
+
+// kdbxStandardFields are the KeePass string fields that map to standard libmutton fields
+// (or are otherwise handled explicitly).
+var kdbxStandardFields = []string{"Title", "UserName", "Password", "URL", "Notes", "otp", "TOTP Seed", "TOTP Settings"}
+
+// xmlNodeT is a minimal DOM node for KDBX XML payloads.
+type xmlNodeT struct {
+	name      string
+	text      string
+	protected bool
+	children  []*xmlNodeT
+}
+
+// child returns the first child of n named name, or nil if none exists.
+func (n *xmlNodeT) child(name string) *xmlNodeT {
+	for _, c := range n.children {
+		if c.name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+// childText returns the text of the first child of n named name.
+func (n *xmlNodeT) childText(name string) string {
+	if c := n.child(name); c != nil {
+		return c.text
+	}
+	return ""
+}
+
+// KeePass imports all entries from a KeePass (KDBX 4) database protected by kdbxPassword
+// (key files are not supported) into folderVanityPath (created if needed).
+// Groups are mapped to folders (the root group maps to folderVanityPath itself),
+// and the recycle bin and entry histories are skipped.
+// Additional string fields are imported as custom fields (hidden if protected in KeePass),
+// and TOTP secrets stored by KeePassXC or KeePass 2 are converted to "otpauth://" URIs.
+// KDBX 3 databases must first be re-saved in KDBX 4 format.
+// If dryRun is true, nothing is written (see ReportT).
+// Leave rcwPassword nil to use RCW demonization.
+func KeePass(kdbxPath string, kdbxPassword []byte, folderVanityPath string, dryRun bool, rcwPassword []byte) (*ReportT, error) {
+	data, err := os.ReadFile(kdbxPath)
+	if err != nil {
+		return nil, errors.New("unable to read database: " + err.Error())
+	}
+	document, err := decryptKDBX(data, kdbxPassword)
+	if err != nil {
+		return nil, err
+	}
+	meta, root := document.child("Meta"), document.child("Root")
+	if meta == nil || root == nil || root.child("Group") == nil {
+		return nil, errors.New("database contains no groups")
+	}
+	var recycleBinUUID string
+	if strings.EqualFold(meta.childText("RecycleBinEnabled"), "True") {
+		recycleBinUUID = meta.childText("RecycleBinUUID")
+	}
+
+	var folders []string
+	var entries []importEntryT
+	var readGroup func(group *xmlNodeT, folder string)
+	readGroup = func(group *xmlNodeT, folder string) {
+		for _, node := range group.children {
+			switch node.name {
+			case "Entry":
+				entry, title := parseKDBXEntry(node)
+				entries = append(entries, importEntryT{folder: folder, name: title, entry: entry})
+			case "Group":
+				if recycleBinUUID != "" && node.childText("UUID") == recycleBinUUID {
+					continue
+				}
+				subfolder := folder + "/" + sanitizeName(node.childText("Name"))
+				folders = append(folders, subfolder)
+				readGroup(node, subfolder)
+			}
+		}
+	}
+	readGroup(root.child("Group"), "")
+	return importEntries(folders, entries, folderVanityPath, CollisionRename, dryRun, rcwPassword)
+}
+
+// parseKDBXEntry converts a KDBX XML entry into an EntryT.
+// Returns: the entry, its title.
+func parseKDBXEntry(node *xmlNodeT) (*core.EntryT, string) {
+	fields := map[string]string{}
+	var keys []string // preserves field order for custom fields
+	protected := map[string]bool{}
+	for _, str := range node.children {
+		if str.name != "String" {
+			continue
+		}
+		key := str.childText("Key")
+		if value := str.child("Value"); value != nil {
+			fields[key] = value.text
+			protected[key] = value.protected
+			keys = append(keys, key)
+		}
+	}
+
+	entry := &core.EntryT{
+		Password: fields["Password"],
+		Username: fields["UserName"],
+		URL:      fields["URL"],
+		TOTP:     getKDBXTOTP(fields, fields["Title"]),
+	}
+	if notes := strings.ReplaceAll(fields["Notes"], "\r\n", "\n"); strings.TrimSpace(notes) != "" {
+		entry.Notes = strings.Split(notes, "\n")
+	}
+	for _, key := range keys {
+		if fields[key] == "" || strings.HasPrefix(key, "TimeOtp-") || strings.HasPrefix(key, "HmacOtp-") || slices.Contains(kdbxStandardFields, key) {
+			continue
+		}
+		addCustomField(entry, key, fields[key], protected[key])
+	}
+	return entry, fields["Title"]
+}
+
+// getKDBXTOTP converts the TOTP/HOTP secret stored in
+// KDBX string fields to a libmutton-compatible TOTP field.
+// Supports KeePassXC ("otp", legacy "TOTP Seed"/"TOTP Settings")
+// and KeePass 2 ("TimeOtp-*", "HmacOtp-*") formats.
+func getKDBXTOTP(fields map[string]string, title string) string {
+	// KeePassXC
+	if uri := strings.TrimSpace(fields["otp"]); uri != "" {
+		if strings.HasPrefix(strings.ToLower(uri), "otpauth://") {
+			return uri
+		}
+		if keeOtp, err := url.ParseQuery(uri); err == nil && keeOtp.Get("key") != "" { // KeeOtp format
+			query := url.Values{"secret": {keeOtp.Get("key")}}
+			if step := keeOtp.Get("step"); step != "" {
+				query.Set("period", step)
+			}
+			if size := keeOtp.Get("size"); size != "" {
+				query.Set("digits", size)
+			}
+			if algorithm := keeOtp.Get("otpHashMode"); algorithm != "" {
+				query.Set("algorithm", strings.ToUpper(algorithm))
+			}
+			return formOTPAuthURI("totp", title, "", query)
+		}
+		return uri
+	}
+	if seed := strings.TrimSpace(fields["TOTP Seed"]); seed != "" {
+		query := url.Values{"secret": {strings.ToUpper(strings.ReplaceAll(seed, " ", ""))}}
+		settings := strings.Split(fields["TOTP Settings"], ";")
+		if len(settings) > 1 && settings[1] == "S" {
+			return "steam@" + query.Get("secret")
+		}
+		if len(settings) > 0 && settings[0] != "" && settings[0] != "30" {
+			query.Set("period", settings[0])
+		}
+		if len(settings) > 1 && settings[1] != "" && settings[1] != "6" {
+			query.Set("digits", settings[1])
+		}
+		return formOTPAuthURI("totp", title, "", query)
+	}
+
+	// KeePass 2
+	for _, otpType := range []string{"TimeOtp", "HmacOtp"} {
+		secret := getKDBXOTPSecret(fields, otpType)
+		if secret == nil {
+			continue
+		}
+		query := url.Values{"secret": {base32NoPadding.EncodeToString(secret)}}
+		if length := fields[otpType+"-Length"]; length != "" {
+			query.Set("digits", length)
+		}
+		if otpType == "HmacOtp" {
+			query.Set("counter", cmpOr(fields["HmacOtp-Counter"], "0"))
+			return formOTPAuthURI("hotp", title, "", query)
+		}
+		if period := fields["TimeOtp-Period"]; period != "" {
+			query.Set("period", period)
+		}
+		if algorithm := fields["TimeOtp-Algorithm"]; algorithm != "" {
+			query.Set("algorithm", strings.ReplaceAll(strings.TrimPrefix(algorithm, "HMAC-"), "-", ""))
+		}
+		return formOTPAuthURI("totp", title, "", query)
+	}
+	return ""
+}
+
+// getKDBXOTPSecret decodes a KeePass 2 OTP secret, which may be stored in one of several encodings.
+// Returns: the raw secret (nil if not present).
+func getKDBXOTPSecret(fields map[string]string, otpType string) []byte {
+	if secret := fields[otpType+"-Secret"]; secret != "" {
+		return []byte(secret)
+	}
+	if secret := fields[otpType+"-Secret-Hex"]; secret != "" {
+		if decoded, err := hex.DecodeString(strings.ReplaceAll(secret, " ", "")); err == nil {
+			return decoded
+		}
+	}
+	if secret := fields[otpType+"-Secret-Base32"]; secret != "" {
+		if decoded, err := base32NoPadding.DecodeString(strings.TrimRight(strings.ToUpper(strings.ReplaceAll(secret, " ", "")), "=")); err == nil {
+			return decoded
+		}
+	}
+	if secret := fields[otpType+"-Secret-Base64"]; secret != "" {
+		if decoded, err := base64.StdEncoding.DecodeString(secret); err == nil {
+			return decoded
+		}
+	}
+	return nil
+}
+
+// decryptKDBX decrypts a KDBX 4 database and returns its XML document root.
+func decryptKDBX(data, password []byte) (*xmlNodeT, error) {
+	if len(data) < 12 || binary.LittleEndian.Uint32(data[0:4]) != 0x9AA2D903 || binary.LittleEndian.Uint32(data[4:8]) != 0xB54BFB67 {
+		return nil, errors.New("file is not a KeePass database")
+	}
+	if major := binary.LittleEndian.Uint16(data[10:12]); major != 4 {
+		return nil, errors.New("unsupported KDBX version " + strconv.Itoa(int(major)) + "; only KDBX 4 is supported")
+	}
+
+	// read outer header
+	var cipherID, masterSeed, iv, kdfParams []byte
+	var compressed bool
+	pos := 12
+	for {
+		if len(data) < pos+5 {
+			return nil, errors.New("database header is truncated")
+		}
+		fieldID, fieldLen := data[pos], int(binary.LittleEndian.Uint32(data[pos+1:pos+5]))
+		pos += 5
+		if fieldLen < 0 || len(data) < pos+fieldLen {
+			return nil, errors.New("database header is truncated")
+		}
+		field := data[pos : pos+fieldLen]
+		pos += fieldLen
+		switch fieldID {
+		case kdbxHeaderCipherID:
+			cipherID = field
+		case kdbxHeaderCompression:
+			compressed = len(field) == 4 && binary.LittleEndian.Uint32(field) == 1
+		case kdbxHeaderMasterSeed:
+			masterSeed = field
+		case kdbxHeaderIV:
+			iv = field
+		case kdbxHeaderKDFParams:
+			kdfParams = field
+		}
+		if fieldID == kdbxHeaderEnd {
+			break
+		}
+	}
+	if len(data) < pos+64 {
+		return nil, errors.New("database header is truncated")
+	}
+	header, headerHash, headerHMAC := data[:pos], data[pos:pos+32], data[pos+32:pos+64]
+	if computed := sha256.Sum256(header); !hmac.Equal(computed[:], headerHash) {
+		return nil, errors.New("database header is corrupted")
+	}
+
+	// derive keys
+	transformedKey, err := transformKDBXKey(password, kdfParams)
+	if err != nil {
+		return nil, err
+	}
+	masterKey := sha256.Sum256(append(bytes.Clone(masterSeed), transformedKey...))
+	hmacBaseKey := sha512.Sum512(append(append(bytes.Clone(masterSeed), transformedKey...), 1))
+	getBlockHMAC := func(index uint64, content ...[]byte) []byte {
+		blockKey := sha512.Sum512(append(binary.LittleEndian.AppendUint64(nil, index), hmacBaseKey[:]...))
+		mac := hmac.New(sha256.New, blockKey[:])
+		for _, c := range content {
+			mac.Write(c)
+		}
+		return mac.Sum(nil)
+	}
+	if !hmac.Equal(getBlockHMAC(^uint64(0), header), headerHMAC) {
+		return nil, errors.New("incorrect database password")
+	}
+
+	// read and verify HMAC blocks
+	var ciphertext []byte
+	pos += 64
+	for index := uint64(0); ; index++ {
+		if len(data) < pos+36 {
+			return nil, errors.New("database is truncated")
+		}
+		blockHMAC, blockLen := data[pos:pos+32], int(binary.LittleEndian.Uint32(data[pos+32:pos+36]))
+		if blockLen < 0 || len(data) < pos+36+blockLen {
+			return nil, errors.New("database is truncated")
+		}
+		block := data[pos+36 : pos+36+blockLen]
+		if !hmac.Equal(getBlockHMAC(index, binary.LittleEndian.AppendUint64(nil, index), data[pos+32:pos+36], block), blockHMAC) {
+			return nil, errors.New("database is corrupted (block " + strconv.FormatUint(index, 10) + ")")
+		}
+		pos += 36 + blockLen
+		if blockLen == 0 {
+			break
+		}
+		ciphertext = append(ciphertext, block...)
+	}
+
+	// decrypt payload
+	var payload []byte
+	switch {
+	case bytes.Equal(cipherID, kdbxCipherAES256):
+		if payload, err = decryptAESCBC(masterKey[:], iv, ciphertext); err != nil {
+			return nil, errors.New("unable to decrypt database: " + err.Error())
+		}
+	case bytes.Equal(cipherID, kdbxCipherChaCha20):
+		stream, err := chacha20.NewUnauthenticatedCipher(masterKey[:], iv)
+		if err != nil {
+			return nil, errors.New("database payload is malformed: " + err.Error())
+		}
+		payload = make([]byte, len(ciphertext))
+		stream.XORKeyStream(payload, ciphertext)
+	default:
+		return nil, errors.New("unsupported database cipher (only AES-256 and ChaCha20 are supported)")
+	}
+	if compressed {
+		reader, err := gzip.NewReader(bytes.NewReader(payload))
+		if err != nil {
+			return nil, errors.New("unable to decompress database: " + err.Error())
+		}
+		reader.Multistream(false) // some writers (e.g. gokeepasslib) leave trailing bytes after the gzip member
+		if payload, err = io.ReadAll(io.LimitReader(reader, kdbxMaxPayloadSize+1)); err != nil {
+			return nil, errors.New("unable to decompress database: " + err.Error())
+		}
+		if len(payload) > kdbxMaxPayloadSize {
+			return nil, errors.New("unable to decompress database: decompressed size exceeds " + strconv.Itoa(kdbxMaxPayloadSize>>20) + " MiB")
+		}
+	}
+
+	// read inner header
+	var streamID uint32
+	var streamKey []byte
+	pos = 0
+	for {
+		if len(payload) < pos+5 {
+			return nil, errors.New("database inner header is truncated")
+		}
+		fieldID, fieldLen := payload[pos], int(binary.LittleEndian.Uint32(payload[pos+1:pos+5]))
+		pos += 5
+		if fieldLen < 0 || len(payload) < pos+fieldLen {
+			return nil, errors.New("database inner header is truncated")
+		}
+		field := payload[pos : pos+fieldLen]
+		pos += fieldLen
+		switch fieldID {
+		case kdbxInnerHeaderStreamID:
+			if len(field) == 4 {
+				streamID = binary.LittleEndian.Uint32(field)
+			}
+		case kdbxInnerHeaderStreamKey:
+			streamKey = field
+		}
+		if fieldID == kdbxInnerHeaderEnd {
+			break
+		}
+	}
+	if streamID != kdbxInnerStreamChaCha20 {
+		return nil, errors.New("unsupported inner stream cipher (only ChaCha20 is supported)")
+	}
+	streamKeyHash := sha512.Sum512(streamKey)
+	innerStream, err := chacha20.NewUnauthenticatedCipher(streamKeyHash[:32], streamKeyHash[32:44])
+	if err != nil {
+		return nil, errors.New("unable to initialize inner stream cipher: " + err.Error())
+	}
+
+	return parseKDBXXML(payload[pos:], innerStream)
+}
+
+// transformKDBXKey derives the transformed key from a
+// password using the KDF described by the KDF parameters header.
+func transformKDBXKey(password, kdfParams []byte) ([]byte, error) {
+	params, err := parseVariantDictionary(kdfParams)
+	if err != nil {
+		return nil, errors.New("unable to read KDF parameters: " + err.Error())
+	}
+	passwordHash := sha256.Sum256(password)
+	compositeKey := sha256.Sum256(passwordHash[:])
+	getUint := func(key string) uint64 {
+		switch value := params[key]; len(value) {
+		case 4:
+			return uint64(binary.LittleEndian.Uint32(value))
+		case 8:
+			return binary.LittleEndian.Uint64(value)
+		}
+		return 0
+	}
+
+	switch uuid := params["$UUID"]; {
+	case bytes.Equal(uuid, kdbxKDFAES) || bytes.Equal(uuid, kdbxKDFAESLegacy):
+		block, err := aes.NewCipher(params["S"])
+		if err != nil {
+			return nil, errors.New("invalid AES-KDF seed: " + err.Error())
+		}
+		rounds := getUint("R")
+		if rounds > kdbxMaxAESRounds {
+			return nil, errors.New("unsupported AES-KDF parameters (too many rounds)")
+		}
+		key := compositeKey
+		for range rounds {
+			block.Encrypt(key[:16], key[:16])
+			block.Encrypt(key[16:], key[16:])
+		}
+		transformedKey := sha256.Sum256(key[:])
+		return transformedKey[:], nil
+	case bytes.Equal(uuid, kdbxKDFArgon2d) || bytes.Equal(uuid, kdbxKDFArgon2id):
+		iterations, memory, parallelism := getUint("I"), getUint("M")/1024, getUint("P")
+		if getUint("V") != 0x13 || iterations == 0 || memory == 0 || parallelism == 0 || parallelism > 255 {
+			return nil, errors.New("unsupported Argon2 parameters")
+		}
+		if iterations > kdbxMaxArgon2Iterations || memory > kdbxMaxArgon2Memory {
+			return nil, errors.New("unsupported Argon2 parameters (memory or iterations too high)")
+		}
+		if len(params["K"]) > 0 || len(params["A"]) > 0 {
+			return nil, errors.New("unsupported Argon2 parameters (secret key/associated data)")
+		}
+		if bytes.Equal(uuid, kdbxKDFArgon2id) {
+			return argon2.IDKey(compositeKey[:], params["S"], uint32(iterations), uint32(memory), uint8(parallelism), 32), nil
+		}
+		return argon2dKey(compositeKey[:], params["S"], uint32(iterations), uint32(memory), uint8(parallelism), 32), nil
+	}
+	return nil, errors.New("unsupported key derivation function")
+}
+
+// parseVariantDictionary parses a KDBX 4 VariantDictionary.
+// Values are returned as raw (little-endian) bytes.
+func parseVariantDictionary(data []byte) (map[string][]byte, error) {
+	if len(data) < 2 || data[1] != 1 {
+		return nil, errors.New("unsupported dictionary version")
+	}
+	dictionary := map[string][]byte{}
+	pos := 2
+	for pos < len(data) && data[pos] != 0 {
+		if len(data) < pos+5 {
+			return nil, errors.New("dictionary is truncated")
+		}
+		keyLen := int(binary.LittleEndian.Uint32(data[pos+1 : pos+5]))
+		pos += 5
+		if keyLen < 0 || len(data) < pos+keyLen+4 {
+			return nil, errors.New("dictionary is truncated")
+		}
+		key := string(data[pos : pos+keyLen])
+		valueLen := int(binary.LittleEndian.Uint32(data[pos+keyLen : pos+keyLen+4]))
+		pos += keyLen + 4
+		if valueLen < 0 || len(data) < pos+valueLen {
+			return nil, errors.New("dictionary is truncated")
+		}
+		dictionary[key] = data[pos : pos+valueLen]
+		pos += valueLen
+	}
+	return dictionary, nil
+}
+
+// parseKDBXXML parses a KDBX XML document, decrypting protected values with innerStream.
+// Protected values must be decrypted in document order, as they share a single key stream.
+func parseKDBXXML(document []byte, innerStream *chacha20.Cipher) (*xmlNodeT, error) {
+	decoder := xml.NewDecoder(bytes.NewReader(document))
+	root := &xmlNodeT{}
+	stack := []*xmlNodeT{root}
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, errors.New("unable to parse database XML: " + err.Error())
+		}
+		parent := stack[len(stack)-1]
+		switch t := token.(type) {
+		case xml.StartElement:
+			node := &xmlNodeT{name: t.Name.Local}
+			for _, attr := range t.Attr {
+				if attr.Name.Local == "Protected" && strings.EqualFold(attr.Value, "True") {
+					node.protected = true
+				}
+			}
+			parent.children = append(parent.children, node)
+			stack = append(stack, node)
+		case xml.CharData:
+			parent.text += string(t)
+		case xml.EndElement:
+			if parent.protected {
+				ciphertext, err := base64.StdEncoding.DecodeString(strings.TrimSpace(parent.text))
+				if err != nil {
+					return nil, errors.New("unable to decode protected value: " + err.Error())
+				}
+				innerStream.XORKeyStream(ciphertext, ciphertext)
+				parent.text = string(ciphertext)
+			}
+			if len(stack) > 1 {
+				stack = stack[:len(stack)-1]
+			}
+		}
+	}
+	if document := root.child("KeePassFile"); document != nil {
+		return document, nil
+	}
+	return nil, errors.New("database XML is missing the KeePassFile element")
+}
@@ -0,0 +1,47 @@
+package importer
+
+import (
+	"os"
+	"slices"
+	"testing"
+
+	"github.com/rwinkhart/libmutton/core"
+)
+
+// testdata/kdbx4.kdbx was written by an independent KDBX implementation (gokeepasslib):
+// Argon2d KDF, ChaCha20 cipher, gzip compression, ChaCha20 inner stream; password "libmutton".
+func TestDecryptKDBX(t *testing.T) {
+	data, err := os.ReadFile("testdata/kdbx4.kdbx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = decryptKDBX(data, []byte("wrong")); err == nil {
+		t.Fatal("database was decrypted with the wrong password")
+	}
+	document, err := decryptKDBX(data, []byte("libmutton"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	group := document.child("Root").child("Group").child("Group")
+	if group == nil || group.childText("Name") != "Web" {
+		t.Fatal("group \"Web\" not found")
+	}
+	node := group.child("Entry")
+	if node == nil {
+		t.Fatal("entry not found")
+	}
+	entry, title := parseKDBXEntry(node)
+	if title != "Example" {
+		t.Fatalf("unexpected title %q", title)
+	}
+	if entry.Password != "hunter2" || entry.Username != "user@example.com" || entry.URL != "https://example.com" {
+		t.Fatalf("unexpected entry fields: %+v", entry)
+	}
+	if !slices.Equal(entry.Notes, []string{"first line", "second line"}) {
+		t.Fatalf("unexpected notes: %q", entry.Notes)
+	}
+	if !slices.Equal(entry.Custom, []core.CustomFieldT{{Name: "PIN", Value: "1234", Hidden: true}}) {
+		t.Fatalf("unexpected custom fields: %+v", entry.Custom)
+	}
+}
@@ -0,0 +1,217 @@
+package importer
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rwinkhart/libmutton/core"
+)
+
+// onePasswordExportT is the structure of the "export.data" file in a 1Password (.1pux) export.
+type onePasswordExportT struct {
+	Accounts []struct {
+		Vaults []struct {
+			Attrs struct {
+				Name string `json:"name"`
+			} `json:"attrs"`
+			Items []onePasswordItemT `json:"items"`
+		} `json:"vaults"`
+	} `json:"accounts"`
+}
+
+// onePasswordItemT is a single item in a 1Password export.
+type onePasswordItemT struct {
+	State   string `json:"state"` // "active" or "archived"
+	Details struct {
+		LoginFields []struct {
+			Name        string `json:"name"`
+			Value       string `json:"value"`
+			FieldType   string `json:"fieldType"` // "P" = password
+			Designation string `json:"designation"`
+		} `json:"loginFields"`
+		NotesPlain string `json:"notesPlain"`
+		Password   string `json:"password"`
+		Sections   []struct {
+			Title  string `json:"title"`
+			Fields []struct {
+				Title string                     `json:"title"`
+				ID    string                     `json:"id"`
+				Value map[string]json.RawMessage `json:"value"`
+			} `json:"fields"`
+		} `json:"sections"`
+	} `json:"details"`
+	Overview struct {
+		Title string `json:"title"`
+		URL   string `json:"url"`
+		URLs  []struct {
+			URL string `json:"url"`
+		} `json:"urls"`
+	} `json:"overview"`
+}
+
+// OnePassword imports all items from a 1Password (.1pux) export into folderVanityPath (created if needed).
+// Each vault is mapped to a folder (archived items are placed in an "Archive" subfolder of their vault).
+// Section fields without a standard libmutton equivalent are imported as custom fields
+// (hidden if concealed in 1Password); the first one-time password field is used as the TOTP secret.
+// File attachments and document items are not imported.
+// If dryRun is true, nothing is written (see ReportT).
+// Leave rcwPassword nil to use RCW demonization.
+func OnePassword(exportPath, folderVanityPath string, dryRun bool, rcwPassword []byte) (*ReportT, error) {
+	archive, err := zip.OpenReader(exportPath)
+	if err != nil {
+		return nil, errors.New("unable to open export: " + err.Error())
+	}
+	defer func(archive *zip.ReadCloser) {
+		_ = archive.Close()
+	}(archive)
+	exportFile, err := archive.Open("export.data")
+	if err != nil {
+		return nil, errors.New("unable to find export data in archive: " + err.Error())
+	}
+	data, err := io.ReadAll(exportFile)
+	_ = exportFile.Close()
+	if err != nil {
+		return nil, errors.New("unable to read export data: " + err.Error())
+	}
+	var export onePasswordExportT
+	if err = json.Unmarshal(data, &export); err != nil {
+		return nil, errors.New("unable to parse export data: " + err.Error())
+	}
+
+	var folders []string
+	var entries []importEntryT
+	for _, account := range export.Accounts {
+		for _, vault := range account.Vaults {
+			folder := "/" + sanitizeName(vault.Attrs.Name)
+			folders = append(folders, folder)
+			for _, item := range vault.Items {
+				itemFolder := folder
+				if item.State == "archived" {
+					itemFolder += "/Archive"
+				}
+				entries = append(entries, importEntryT{folder: itemFolder, name: item.Overview.Title, entry: parseOnePasswordItem(&item)})
+			}
+		}
+	}
+	return importEntries(folders, entries, folderVanityPath, CollisionRename, dryRun, rcwPassword)
+}
+
+// parseOnePasswordItem converts a 1Password item into an EntryT.
+func parseOnePasswordItem(item *onePasswordItemT) *core.EntryT {
+	entry := &core.EntryT{Password: item.Details.Password, URL: item.Overview.URL}
+
+	// login fields
+	for _, field := range item.Details.LoginFields {
+		switch {
+		case field.Value == "":
+		case field.Designation == "username" && entry.Username == "":
+			entry.Username = field.Value
+		case field.Designation == "password" && entry.Password == "":
+			entry.Password = field.Value
+		default:
+			addCustomField(entry, cmpOr(field.Name, "field"), field.Value, field.FieldType == "P")
+		}
+	}
+
+	// URLs
+	for _, url := range item.Overview.URLs {
+		if entry.URL == "" {
+			entry.URL = url.URL
+		} else if url.URL != "" && url.URL != entry.URL {
+			addCustomField(entry, "URL", url.URL, false)
+		}
+	}
+
+	// notes
+	if notes := strings.ReplaceAll(item.Details.NotesPlain, "\r\n", "\n"); strings.TrimSpace(notes) != "" {
+		entry.Notes = strings.Split(notes, "\n")
+	}
+
+	// section fields
+	for _, section := range item.Details.Sections {
+		for _, field := range section.Fields {
+			for kind, raw := range field.Value {
+				value, hidden := getOnePasswordValue(kind, raw)
+				if value == "" {
+					continue
+				}
+				if kind == "totp" && entry.TOTP == "" {
+					entry.TOTP = value
+					continue
+				}
+				name := cmpOr(field.Title, field.ID)
+				if section.Title != "" {
+					name = section.Title + " - " + name
+				}
+				addCustomField(entry, name, value, hidden)
+			}
+		}
+	}
+	return entry
+}
+
+// getOnePasswordValue converts a 1Password field value of the given kind to a string.
+// Returns: the value, whether the value should be hidden.
+func getOnePasswordValue(kind string, raw json.RawMessage) (string, bool) {
+	switch kind {
+	case "date": // Unix timestamp
+		var timestamp int64
+		if json.Unmarshal(raw, &timestamp) == nil && timestamp != 0 {
+			return time.Unix(timestamp, 0).UTC().Format(time.DateOnly), false
+		}
+	case "monthYear": // YYYYMM
+		var monthYear int
+		if json.Unmarshal(raw, &monthYear) == nil && monthYear != 0 {
+			return strconv.Itoa(monthYear%100) + "/" + strconv.Itoa(monthYear/100), false
+		}
+	case "email":
+		var email struct {
+			Address string `json:"email_address"`
+		}
+		if json.Unmarshal(raw, &email) == nil {
+			return email.Address, false
+		}
+	case "address":
+		var address struct {
+			Street  string `json:"street"`
+			City    string `json:"city"`
+			State   string `json:"state"`
+			Zip     string `json:"zip"`
+			Country string `json:"country"`
+		}
+		if json.Unmarshal(raw, &address) == nil {
+			var parts []string
+			for _, part := range []string{address.Street, address.City, address.State, address.Zip, address.Country} {
+				if part != "" {
+					parts = append(parts, part)
+				}
+			}
+			return strings.Join(parts, ", "), false
+		}
+	case "sshKey":
+		var sshKey struct {
+			PrivateKey string `json:"privateKey"`
+		}
+		if json.Unmarshal(raw, &sshKey) == nil {
+			return sshKey.PrivateKey, true
+		}
+	default: // string, concealed, totp, url, phone, creditCardNumber, menu, etc.
+		var value any
+		if json.Unmarshal(raw, &value) == nil {
+			switch v := value.(type) {
+			case string:
+				return v, kind == "concealed" || kind == "creditCardNumber"
+			case float64:
+				return strconv.FormatFloat(v, 'f', -1, 64), false
+			case bool:
+				return strconv.FormatBool(v), false
+			}
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,134 @@
+package importer
+
+import (
+	"bytes"
+	"errors"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/rwinkhart/libmutton/core"
+)
+
+// passFieldPrefixes maps common pass metadata line prefixes (lowercase) to the field they populate.
+var passFieldPrefixes = []struct {
+	prefix string
+	field  func(entry *core.EntryT) *string
+}{
+	{"login:", func(entry *core.EntryT) *string { return &entry.Username }},
+	{"username:", func(entry *core.EntryT) *string { return &entry.Username }},
+	{"user:", func(entry *core.EntryT) *string { return &entry.Username }},
+	{"email:", func(entry *core.EntryT) *string { return &entry.Username }},
+	{"url:", func(entry *core.EntryT) *string { return &entry.URL }},
+	{"website:", func(entry *core.EntryT) *string { return &entry.URL }},
+}
+
+// Pass imports all entries from a pass (password-store) directory into folderVanityPath (created if needed).
+// Leave storePath empty to use $PASSWORD_STORE_DIR (or ~/.password-store, if unset).
+// Entries are decrypted with the local gpg binary (gpg-agent prompts for the key's passphrase, if needed).
+// The first line of each entry is used as the password, "login:"/"url:" (and similar) lines
+// populate the username and URL fields, and the first "otpauth://" line is used as the TOTP secret.
+// All other lines are kept as notes. Entries are only written once all of them have been decrypted
+// (entries that cannot be decrypted are reported as failures).
+// If dryRun is true, nothing is written (see ReportT).
+// Leave rcwPassword nil to use RCW demonization.
+func Pass(storePath, folderVanityPath string, dryRun bool, rcwPassword []byte) (*ReportT, error) {
+	if storePath == "" {
+		storePath = os.Getenv("PASSWORD_STORE_DIR")
+	}
+	if storePath == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, errors.New("unable to determine home directory: " + err.Error())
+		}
+		storePath = filepath.Join(home, ".password-store")
+	}
+	gpgPath, err := exec.LookPath("gpg")
+	if err != nil {
+		if gpgPath, err = exec.LookPath("gpg2"); err != nil {
+			return nil, errors.New("unable to find gpg binary: " + err.Error())
+		}
+	}
+
+	var folders []string
+	var entries []importEntryT
+	err = filepath.WalkDir(storePath, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if path == storePath {
+			return nil
+		}
+		if strings.HasPrefix(d.Name(), ".") { // skip .git, .extensions, .gpg-id, etc.
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		relativePath, _ := filepath.Rel(storePath, path)
+		var folder string
+		for _, element := range strings.Split(filepath.ToSlash(filepath.Dir(relativePath)), "/") {
+			if element != "." {
+				folder += "/" + sanitizeName(element)
+			}
+		}
+		if d.IsDir() {
+			folders = append(folders, folder+"/"+sanitizeName(d.Name()))
+			return nil
+		}
+		name, isEntry := strings.CutSuffix(d.Name(), ".gpg")
+		if !isEntry {
+			return nil
+		}
+
+		// decrypt entry
+		var stdout, stderr bytes.Buffer
+		cmd := exec.Command(gpgPath, "--quiet", "--yes", "--decrypt", path)
+		cmd.Stdout, cmd.Stderr = &stdout, &stderr
+		err = cmd.Run()
+		if err != nil {
+			clear(stdout.Bytes()) // zeroize any partial plaintext
+			entries = append(entries, importEntryT{source: relativePath, folder: folder, name: name, failed: "unable to decrypt: " + err.Error() + ": " + strings.TrimSpace(stderr.String())})
+			return nil
+		}
+		entries = append(entries, importEntryT{source: relativePath, folder: folder, name: name, entry: parsePassEntry(stdout.String())})
+		clear(stdout.Bytes()) // zeroize plaintext
+		return nil
+	})
+	if err != nil {
+		return nil, errors.New("unable to read password store: " + err.Error())
+	}
+	return importEntries(folders, entries, folderVanityPath, CollisionRename, dryRun, rcwPassword)
+}
+
+// parsePassEntry converts a decrypted pass entry into an EntryT.
+func parsePassEntry(plaintext string) *core.EntryT {
+	lines := strings.Split(strings.ReplaceAll(plaintext, "\r\n", "\n"), "\n")
+	entry := &core.EntryT{Password: lines[0]}
+	for _, line := range lines[1:] {
+		trimmed := strings.TrimSpace(line)
+		lower := strings.ToLower(trimmed)
+		if entry.TOTP == "" && strings.HasPrefix(lower, "otpauth://") {
+			entry.TOTP = trimmed
+			continue
+		}
+		var isField bool
+		for _, fieldPrefix := range passFieldPrefixes {
+			if field := fieldPrefix.field(entry); *field == "" && strings.HasPrefix(lower, fieldPrefix.prefix) {
+				*field = strings.TrimSpace(trimmed[len(fieldPrefix.prefix):])
+				isField = *field != ""
+				break
+			}
+		}
+		if !isField {
+			entry.Notes = append(entry.Notes, line)
+		}
+	}
+	// remove trailing empty note lines (pass entries typically end with a newline)
+	for len(entry.Notes) > 0 && strings.TrimSpace(entry.Notes[len(entry.Notes)-1]) == "" {
+		entry.Notes = entry.Notes[:len(entry.Notes)-1]
+	}
+	return entry
+}
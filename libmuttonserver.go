@@ -55,13 +55,23 @@ func main() {
 	case "fetch":
 		// print all information needed for syncing to stdout for interpretation by the client
 		// stdin[0] is expected to be the device ID
-		syncserver.GetRemoteDataFromServer(stdin[0])
+		// stdin[1] (optional) is expected to be the change journal cursor from the client's previous fetch
+		var cursor string
+		if len(stdin) > 1 {
+			cursor = stdin[1]
+		}
+		syncserver.GetRemoteDataFromServer(stdin[0], cursor)
 	case "rename":
 		// move an entry to a new location before using fallthrough to add its previous iteration to the deletions directory
 		// stdin[0] is evaluated after fallthrough
 		// stdin[1] is expected to be the OLD vanityPath with FSPath representing path separators - Always pass in UNIX format
 		// stdin[2] is expected to be the NEW vanityPath with FSPath representing path separators - Always pass in UNIX format
-		if err := synccommon.RenameLocal(strings.ReplaceAll(stdin[1], global.FSPath, "/"), strings.ReplaceAll(stdin[2], global.FSPath, "/")); err != nil {
+		oldVanityPath, newVanityPath := strings.ReplaceAll(stdin[1], global.FSPath, "/"), strings.ReplaceAll(stdin[2], global.FSPath, "/")
+		if err := synccommon.RenameLocal(oldVanityPath, newVanityPath); err != nil {
+			fmt.Printf("{\"errMsg\":\"%s\"}", err.Error())
+			return
+		}
+		if err := syncserver.AppendJournal(syncserver.JournalRecordT{Op: syncserver.JournalOpRename, VanityPath: oldVanityPath, NewVanityPath: newVanityPath}); err != nil {
 			fmt.Printf("{\"errMsg\":\"%s\"}", err.Error())
 			return
 		}
@@ -70,24 +80,50 @@ func main() {
 		// shear an entry from the server and add it to the deletions directory
 		// stdin[0] is expected to be the device ID
 		// stdin[1] is expected to be the vanityPath with FSPath representing path separators - Always pass in UNIX format
-		_, _, err := synccommon.ShearLocal(strings.ReplaceAll(stdin[1], global.FSPath, "/"), stdin[0], false)
+		vanityPath := strings.ReplaceAll(stdin[1], global.FSPath, "/")
+		_, _, err := synccommon.ShearLocal(vanityPath, stdin[0], false)
 		if err != nil {
 			fmt.Printf("{\"errMsg\":\"%s\"}", err.Error())
 			return
 		}
+		if err = syncserver.AppendJournal(syncserver.JournalRecordT{Op: syncserver.JournalOpShear, VanityPath: vanityPath}); err != nil {
+			fmt.Printf("{\"errMsg\":\"%s\"}", err.Error())
+			return
+		}
 	case "shear-age":
 		// shear ONLY the age file associated with an entry from the server and add it to the deletions directory
 		// stdin[0] is expected to be the device ID
 		// stdin[1] is expected to be the vanityPath with FSPath representing path separators - Always pass in UNIX format
-		_, _, err := synccommon.ShearLocal(strings.ReplaceAll(stdin[1], global.FSPath, "/"), stdin[0], true)
+		vanityPath := strings.ReplaceAll(stdin[1], global.FSPath, "/")
+		_, _, err := synccommon.ShearLocal(vanityPath, stdin[0], true)
 		if err != nil {
 			fmt.Printf("{\"errMsg\":\"%s\"}", err.Error())
 			return
 		}
+		if err = syncserver.AppendJournal(syncserver.JournalRecordT{Op: syncserver.JournalOpShear, VanityPath: vanityPath}); err != nil {
+			fmt.Printf("{\"errMsg\":\"%s\"}", err.Error())
+			return
+		}
 	case "addfolder":
 		// add a new folder to the server
 		// stdin[0] is expected to be the vanityPath with FSPath representing path separators - Always pass in UNIX format
-		if err := synccommon.AddFolderLocal(strings.ReplaceAll(stdin[0], global.FSPath, "/")); err != nil {
+		vanityPath := strings.ReplaceAll(stdin[0], global.FSPath, "/")
+		if err := synccommon.AddFolderLocal(vanityPath); err != nil {
+			fmt.Printf("{\"errMsg\":\"%s\"}", err.Error())
+			return
+		}
+		if err := syncserver.AppendJournal(syncserver.JournalRecordT{Op: syncserver.JournalOpAddFolder, VanityPath: vanityPath}); err != nil {
+			fmt.Printf("{\"errMsg\":\"%s\"}", err.Error())
+			return
+		}
+	case "journal":
+		// record entries (or their age files) written by the client over SFTP in the change journal
+		// each line of stdin is expected to be a vanityPath with FSPath representing path separators - Always pass in UNIX format
+		records := make([]syncserver.JournalRecordT, len(stdin))
+		for i := range stdin {
+			records[i] = syncserver.JournalRecordT{Op: syncserver.JournalOpWrite, VanityPath: strings.ReplaceAll(stdin[i], global.FSPath, "/")}
+		}
+		if err := syncserver.AppendJournal(records...); err != nil {
 			fmt.Printf("{\"errMsg\":\"%s\"}", err.Error())
 			return
 		}
@@ -137,6 +173,15 @@ func main() {
 			return
 		}
 		fmt.Print(string(registerRespBytes))
+	case "rekey":
+		// store the RCW sanity check file for a new key generation
+		// stdin[0] is expected to be JSON matching type synccommon.RekeyReqT
+		var rekeyReq synccommon.RekeyReqT
+		if err := json.Unmarshal([]byte(stdin[0]), &rekeyReq); err != nil {
+			fmt.Printf("{\"errMsg\":\"%s\"}", err.Error())
+			return
+		}
+		syncserver.Rekey(&rekeyReq)
 	case "init":
 		// create the necessary directories for libmuttonserver to function
 		_, err := global.DirInit(false)
@@ -0,0 +1,155 @@
+package qr
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	_ "image/gif"  // register GIF decoding for DecodeFile
+	_ "image/jpeg" // register JPEG decoding for DecodeFile
+	"image/png"
+	"os"
+	"strings"
+
+	"github.com/boombuler/barcode"
+	qrenc "github.com/boombuler/barcode/qr"
+	"github.com/makiuchi-d/gozxing"
+	"github.com/makiuchi-d/gozxing/qrcode"
+	"github.com/rwinkhart/libmutton/core"
+	"github.com/rwinkhart/libmutton/global"
+)
+
+// GetOTPAuthURI returns the TOTP field of the entry at
+// vanityPath as an "otpauth://" URI suitable for QR export.
+// Bare secrets are labeled with the entry name (as the issuer) and the username (as the account name).
+// Leave rcwPassword nil to use RCW demonization.
+func GetOTPAuthURI(vanityPath string, rcwPassword []byte) (string, error) {
+	decSlice, err := core.GetOldEntryData(global.GetRealPath(vanityPath), global.FieldTOTP, rcwPassword)
+	if err != nil {
+		return "", err
+	}
+	secret := strings.TrimSpace(decSlice[global.FieldTOTP])
+	if secret == "" {
+		return "", errors.New("entry does not contain a TOTP secret")
+	}
+	return core.FormOTPAuthURI(secret, vanityPath[strings.LastIndex(vanityPath, "/")+1:], decSlice[global.FieldUsername])
+}
+
+// EncodePNG renders content as a QR code and returns it as PNG-encoded bytes.
+// size is the width/height of the output image in pixels.
+func EncodePNG(content string, size int) ([]byte, error) {
+	code, err := qrenc.Encode(content, qrenc.M, qrenc.Auto)
+	if err != nil {
+		return nil, errors.New("unable to encode QR code: " + err.Error())
+	}
+	code, err = barcode.Scale(code, size, size)
+	if err != nil {
+		return nil, errors.New("unable to scale QR code: " + err.Error())
+	}
+	var pngBuffer bytes.Buffer
+	if err = png.Encode(&pngBuffer, code); err != nil {
+		return nil, errors.New("unable to encode PNG: " + err.Error())
+	}
+	return pngBuffer.Bytes(), nil
+}
+
+// EncodeText renders content as a QR code using Unicode half-block characters
+// (two modules per character) for display in a terminal.
+// By default, the output is meant for terminals with light text on a dark
+// background; set invert to true for dark text on a light background.
+func EncodeText(content string, invert bool) (string, error) {
+	code, err := qrenc.Encode(content, qrenc.M, qrenc.Auto)
+	if err != nil {
+		return "", errors.New("unable to encode QR code: " + err.Error())
+	}
+	const quietZone = 2 // modules of padding around the code
+	bounds := code.Bounds()
+	// isLight returns whether the module at (x, y) should be printed (lit) in the terminal
+	isLight := func(x, y int) bool {
+		var isDark bool
+		if x >= bounds.Min.X && x < bounds.Max.X && y >= bounds.Min.Y && y < bounds.Max.Y {
+			r, _, _, _ := code.At(x, y).RGBA()
+			isDark = r < 0x8000
+		}
+		return isDark == invert
+	}
+	var builder strings.Builder
+	for y := bounds.Min.Y - quietZone; y < bounds.Max.Y+quietZone; y += 2 {
+		for x := bounds.Min.X - quietZone; x < bounds.Max.X+quietZone; x++ {
+			switch top, bottom := isLight(x, y), isLight(x, y+1); {
+			case top && bottom:
+				builder.WriteString("█")
+			case top:
+				builder.WriteString("▀")
+			case bottom:
+				builder.WriteString("▄")
+			default:
+				builder.WriteString(" ")
+			}
+		}
+		builder.WriteString("\n")
+	}
+	return builder.String(), nil
+}
+
+// DecodeFile decodes the QR code contained in a PNG, JPEG, or GIF image file and returns its contents.
+func DecodeFile(imagePath string) (string, error) {
+	f, err := os.Open(imagePath)
+	if err != nil {
+		return "", errors.New("unable to open image: " + err.Error())
+	}
+	defer func(f *os.File) {
+		_ = f.Close()
+	}(f)
+	img, _, err := image.Decode(f)
+	if err != nil {
+		return "", errors.New("unable to decode image: " + err.Error())
+	}
+	bitmap, err := gozxing.NewBinaryBitmapFromImage(img)
+	if err != nil {
+		return "", errors.New("unable to read image: " + err.Error())
+	}
+	result, err := qrcode.NewQRCodeReader().Decode(bitmap, nil)
+	if err != nil {
+		return "", errors.New("unable to find QR code in image: " + err.Error())
+	}
+	return result.GetText(), nil
+}
+
+// DecodeTOTPFile decodes an "otpauth://" QR code from an image
+// file and returns it in a form suitable for an entry's TOTP field.
+func DecodeTOTPFile(imagePath string) (string, error) {
+	content, err := DecodeFile(imagePath)
+	if err != nil {
+		return "", err
+	}
+	if !strings.HasPrefix(strings.ToLower(content), "otpauth://") {
+		return "", errors.New("QR code does not contain an otpauth URI")
+	}
+	if _, err = core.ParseTOTP(content); err != nil {
+		return "", err
+	}
+	return content, nil
+}
+
+// ImportTOTPFile decodes an "otpauth://" QR code from an image file and writes
+// it to the TOTP field of the entry at realPath (created if it does not exist).
+// Leave rcwPassword nil to use RCW demonization.
+func ImportTOTPFile(realPath, imagePath string, rcwPassword []byte) error {
+	secret, err := DecodeTOTPFile(imagePath)
+	if err != nil {
+		return err
+	}
+
+	// load existing entry data (if the entry exists)
+	decSlice := make([]string, global.FieldTOTP+1)
+	if _, err = os.Stat(realPath); err == nil {
+		// clone rcwPassword, as it is zeroized after use (nil is preserved for RCW demonization)
+		decSlice, err = core.GetOldEntryData(realPath, global.FieldTOTP, bytes.Clone(rcwPassword))
+		if err != nil {
+			return err
+		}
+	}
+
+	decSlice[global.FieldTOTP] = secret
+	return core.WriteEntry(realPath, decSlice, false, rcwPassword)
+}
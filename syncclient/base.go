@@ -0,0 +1,121 @@
+package syncclient
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+
+	"github.com/rwinkhart/libmutton/global"
+	"github.com/rwinkhart/libmutton/synccommon"
+)
+
+// The base state records each entry's state as of the last successful sync
+// (at which point the client and server versions were identical).
+// Comparing both sides against it allows RunJob to tell which side changed an entry;
+// if both sides changed it, the entry is in conflict (see ResolveConflict and ListConflicts).
+// Entries without a base state (e.g. on the first sync) fall back to the newer version winning.
+
+// baseStateT maps vanity paths to their state as of the last successful sync.
+type baseStateT map[string]baseEntryT
+type baseEntryT struct {
+	ModTime int64 `json:"modTime"`
+}
+
+// ConflictResolutionT determines how an entry that was changed on both the client and server is synced.
+type ConflictResolutionT uint8
+
+const (
+	ConflictSkip       ConflictResolutionT = iota // sync neither version (the conflict is reported again on the next sync)
+	ConflictKeepLocal                             // overwrite the server's version with the client's
+	ConflictKeepRemote                            // overwrite the client's version with the server's
+	ConflictKeepBoth                              // keep the server's version and sync the client's as a conflict copy (see ListConflicts)
+)
+
+// ResolveConflict is called by RunJob for each entry that was changed on both the client and server since the last sync.
+// Clients may set this to prompt the user; if it is nil, both versions are kept (ConflictKeepBoth).
+// It is not called if the client and server clocks are out of sync (dry sync).
+var ResolveConflict func(vanityPath string, localInfo, remoteInfo synccommon.EntryT) ConflictResolutionT
+
+// sync directions determined by getSyncDirection
+const (
+	syncNone uint8 = iota
+	syncDownload
+	syncUpload
+	syncConflict
+	syncReconcile
+)
+
+// getSyncDirection determines how an entry present on both the client and server must be synced.
+// baseInfo should be nil if the entry has no base state.
+func getSyncDirection(localInfo, remoteInfo synccommon.EntryT, baseInfo *baseEntryT) uint8 {
+	switch {
+	case localInfo.ModTime == remoteInfo.ModTime:
+		return syncNone
+	case localInfo.Hash != "" && localInfo.Hash == remoteInfo.Hash:
+		return syncReconcile // identical contents; only the timestamps differ
+	case baseInfo == nil:
+		// unknown base state; fall back to keeping the newer version
+		if remoteInfo.ModTime > localInfo.ModTime {
+			return syncDownload
+		}
+		return syncUpload
+	case localInfo.ModTime == baseInfo.ModTime:
+		return syncDownload // only changed on server
+	case remoteInfo.ModTime == baseInfo.ModTime:
+		return syncUpload // only changed on client
+	default:
+		return syncConflict
+	}
+}
+
+// addLocalHash returns localInfo with the hash of the local entry at vanityPath set,
+// if it is needed to compare the entry with remoteInfo (see getSyncDirection).
+// Entries are only hashed if their mod times differ, as hashing every entry on every sync is slow.
+func addLocalHash(vanityPath string, localInfo, remoteInfo synccommon.EntryT) (synccommon.EntryT, error) {
+	if localInfo.Hash != "" || remoteInfo.Hash == "" || localInfo.ModTime == remoteInfo.ModTime {
+		return localInfo, nil
+	}
+	hash, err := synccommon.GetEntryHash(vanityPath)
+	if err != nil {
+		return localInfo, err
+	}
+	localInfo.Hash = hash
+	return localInfo, nil
+}
+
+// getBaseStatePath returns the path to the sync base state file.
+func getBaseStatePath() string {
+	return global.CfgDir + global.PathSeparator + "syncbase.json"
+}
+
+// loadBaseState returns the base state saved by the last successful sync (empty if there is none).
+func loadBaseState() (baseStateT, error) {
+	baseState := make(baseStateT)
+	baseStateBytes, err := os.ReadFile(getBaseStatePath())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return baseState, nil
+		}
+		return nil, errors.New("unable to read sync base state: " + err.Error())
+	}
+	if err = json.Unmarshal(baseStateBytes, &baseState); err != nil {
+		return nil, errors.New("unable to unmarshal sync base state: " + err.Error())
+	}
+	return baseState, nil
+}
+
+// saveBaseState atomically replaces the saved base state.
+func saveBaseState(baseState baseStateT) error {
+	baseStateBytes, err := json.Marshal(baseState)
+	if err != nil {
+		return errors.New("unable to marshal sync base state: " + err.Error())
+	}
+	baseStatePath := getBaseStatePath()
+	if err = os.WriteFile(baseStatePath+"-tmp", baseStateBytes, 0600); err != nil {
+		return errors.New("unable to write sync base state: " + err.Error())
+	}
+	if err = os.Rename(baseStatePath+"-tmp", baseStatePath); err != nil {
+		return errors.New("unable to write sync base state: " + err.Error())
+	}
+	return nil
+}
@@ -1,214 +1,82 @@
 package syncclient
 
 import (
-	"encoding/json"
 	"errors"
 	"fmt"
 	"os"
 	"strings"
 	"time"
 
-	"github.com/pkg/sftp"
 	"github.com/rwinkhart/go-boilerplate/back"
 	"github.com/rwinkhart/libmutton/age"
-	"github.com/rwinkhart/libmutton/config"
 	"github.com/rwinkhart/libmutton/global"
-	"github.com/rwinkhart/libmutton/privkey"
+	"github.com/rwinkhart/libmutton/history"
 	"github.com/rwinkhart/libmutton/synccommon"
-	"golang.org/x/crypto/ssh"
-	"golang.org/x/crypto/ssh/knownhosts"
 )
 
 type syncListsT struct {
-	Delete   []string
-	Download []string
-	Upload   []string
-}
-
-// GetSSHClient
-// Returns:
-// sshClient,
-// offlineMode (whether the client is in offline mode).
-// sshIsWindows (whether the remote server is running Windows),
-// sshEntryRoot (the root directory for entries on the remote server),
-// Only supports key-based authentication (passwords are supported for CLI-based implementations).
-func GetSSHClient() (*ssh.Client, bool, *bool, *string, *string, error) {
-	// get SSH config info
-	cfg, err := config.Load()
-	if err != nil {
-		return nil, false, nil, nil, nil, errors.New("unable to parse SSH config: " + err.Error())
-	}
-	if *cfg.Libmutton.OfflineMode {
-		return nil, true, nil, nil, nil, nil
-	}
-
-	// get private key
-	key, err := privkey.GetBytes(cfg.Libmutton.SSHKeyPath)
-	if err != nil {
-		return nil, false, nil, nil, nil, err
-	}
-
-	// parse private key
-	var parsedKey ssh.Signer
-	if !*cfg.Libmutton.SSHKeyProtected {
-		parsedKey, err = ssh.ParsePrivateKey(key)
-	} else {
-		parsedKey, err = ssh.ParsePrivateKeyWithPassphrase(key, global.GetPassword("Enter password for your SSH keyfile:"))
-	}
-	if err != nil {
-		return nil, false, nil, nil, nil, errors.New("unable to parse private key: " + err.Error())
-	}
-
-	// read known hosts file
-	var hostKeyCallback ssh.HostKeyCallback
-	hostKeyCallback, err = knownhosts.New(global.SSHDir + global.PathSeparator + "known_hosts")
-	if err != nil {
-		return nil, false, nil, nil, nil, errors.New("unable to read known hosts file: " + err.Error())
-	}
-
-	// configure SSH client
-	sshCfg := &ssh.ClientConfig{
-		User: *cfg.Libmutton.SSHUser,
-		Auth: []ssh.AuthMethod{
-			ssh.PublicKeys(parsedKey),
-		},
-		HostKeyCallback: hostKeyCallback,
-		Timeout:         3 * time.Second,
-	}
-
-	// connect to SSH server
-	sshClient, err := ssh.Dial("tcp", *cfg.Libmutton.SSHIP+":"+*cfg.Libmutton.SSHPort, sshCfg)
-	if err != nil {
-		return nil, false, nil, nil, nil, errors.New("unable to connect to remote server: " + err.Error())
-	}
-
-	return sshClient, false, cfg.Libmutton.SSHIsWindows, cfg.Libmutton.SSHEntryRootPath, cfg.Libmutton.SSHAgeDirPath, nil
-}
-
-// GetSSHOutput runs a command over SSH and returns the output as a string.
-func GetSSHOutput(sshClient *ssh.Client, cmd, stdin string) ([]byte, error) {
-	// create a session
-	sshSession, err := sshClient.NewSession()
-	if err != nil {
-		return nil, errors.New("unable to establish SSH session: " + err.Error())
-	}
-
-	// provide stdin data for session
-	sshSession.Stdin = strings.NewReader(stdin)
-
-	// run the provided command
-	var output []byte
-	output, err = sshSession.CombinedOutput(cmd)
-	if err != nil {
-		return nil, errors.New("unable to run SSH command: " + err.Error())
-	}
-
-	return output, nil
+	Delete    []string
+	Download  []string
+	Upload    []string
+	Conflict  []string // entries changed on both client and server (see ResolveConflict)
+	Reconcile []string // entries with identical contents on client and server (only their timestamps were synced)
 }
 
 // getRemoteDataFromClient returns:
-// a map of remote vanityPaths to their containing folders and mod+age timestamps,
-// a list of queued deletions,
-// and the current server&client times as UNIX timestamps.
-func getRemoteDataFromClient(sshClient *ssh.Client) (synccommon.EntryMapT, []synccommon.Deletion, int64, int64, error) {
-	// get remote output over SSH
+// the server's fetch response (remote vanityPaths mapped to their containing folders and mod+age timestamps,
+// queued deletions, the server time, and the current key generation),
+// and the current client time as a UNIX timestamp.
+// If the server supports it, only the changes since the last fetch are requested (see remoteCacheT).
+func getRemoteDataFromClient(transport TransportT) (*synccommon.FetchRespT, int64, error) {
 	deviceIDList, err := global.GenDeviceIDList()
 	if err != nil {
-		return nil, nil, 0, 0, err
+		return nil, 0, err
 	}
 	if len(deviceIDList) == 0 {
-		return nil, nil, 0, 0, errors.New("no device ID found")
+		return nil, 0, errors.New("no device ID found")
 	}
 	clientTime := time.Now().Unix() // get client time now to avoid accuracy issues caused by unpredictable sync time
-	output, err := GetSSHOutput(sshClient, "libmuttonserver fetch", (deviceIDList)[0].Name())
+	remoteCache := loadRemoteCache()
+	fetchResp, err := transport.Fetch((deviceIDList)[0].Name(), getFetchCursor(remoteCache))
 	if err != nil {
-		return nil, nil, 0, 0, errors.New("unable to run remote command: " + err.Error())
-	}
-
-	var fetchResp synccommon.FetchRespT
-	if err = json.Unmarshal(output, &fetchResp); err != nil {
-		fmt.Println(string(output))
-		return nil, nil, 0, 0, errors.New("unable to unmarshal server fetch response: " + err.Error())
-	}
-	if fetchResp.ErrMsg != nil {
-		return nil, nil, 0, 0, errors.New("unable to complete fetch; server-side error occurred: " + strings.ReplaceAll(*fetchResp.ErrMsg, global.FSSpace, "\n"))
-	}
-
-	return fetchResp.Entries, fetchResp.Deletions, fetchResp.ServerTime, clientTime, nil
-}
-
-// getRealPathSFTP formats the vanityPath to match the remote server's entry/age file directory and path separator.
-func getRealPathSFTP(vanityPath, serverEntryRoot string, serverIsWindows bool) string {
-	if !serverIsWindows {
-		return serverEntryRoot + vanityPath
+		return nil, 0, err
 	}
-	return serverEntryRoot + strings.ReplaceAll(vanityPath, "/", "\\")
-}
-
-// getRealPathSFTP formats the vanityPath to match the remote server's entry/age file directory and path separator.
-func getRealAgePathSFTP(vanityPath, serverAgeDir string, serverIsWindows bool) string {
-	if !serverIsWindows {
-		return serverAgeDir + "/" + strings.ReplaceAll(vanityPath, "/", global.FSPath)
+	applyFetchResp(remoteCache, fetchResp)
+	if err = saveRemoteCache(remoteCache); err != nil {
+		return nil, 0, err
 	}
-	return serverAgeDir + "\\" + strings.ReplaceAll(vanityPath, "/", global.FSPath)
+	return fetchResp, clientTime, nil
 }
 
-// sftpSync takes two slices of entries (one for downloads and one for uploads) and syncs them between the client and server using SFTP.
-func sftpSync(sshClient *ssh.Client, sshEntryRoot, sshAgeDir string, sshIsWindows bool, syncListsV *syncListsT) error {
-	// create an SFTP client from sshClient
-	sftpClient, err := sftp.NewClient(sshClient)
-	if err != nil {
-		return errors.New("unable to establish SFTP session: " + err.Error())
-	}
-	defer func(sftpClient *sftp.Client) {
-		_ = sftpClient.Close()
-	}(sftpClient)
-
+// transferFiles takes two slices of entries (one for downloads and one for uploads) and syncs them between the client and server.
+func transferFiles(transport TransportT, syncListsV *syncListsT) error {
 	// iterate over the download list
 	var filesTransferred bool
 	for _, vanityPath := range syncListsV.Download {
 		filesTransferred = true // set a flag to indicate that files have been downloaded (used to determine whether to print a gap between download and upload messages)
 		fmt.Println("Downloading " + back.AnsiGreen + vanityPath + back.AnsiReset)
 
-		// store path to remote entry
-		remoteFileRealPath := getRealPathSFTP(vanityPath, sshEntryRoot, sshIsWindows)
-
-		// save modification time of remote file
-		var fileInfo os.FileInfo
-		fileInfo, err = sftpClient.Stat(remoteFileRealPath)
-		if err != nil {
-			return errors.New("unable to get remote file info (mod time): " + err.Error())
-		}
-		modTime := fileInfo.ModTime()
-
-		// open remote file
-		var remoteFile *sftp.File
-		remoteFile, err = sftpClient.Open(remoteFileRealPath)
-		if err != nil {
-			return errors.New("unable to open remote file: " + err.Error())
-		}
-
 		// store path to local file
 		localFileRealPath := global.GetRealPath(vanityPath)
 
+		// keep the local version (if any) in the revision history before it is overwritten
+		if err := history.Save(vanityPath); err != nil {
+			return errors.New("unable to save revision history: " + err.Error())
+		}
+
 		// create local file
-		var localFile *os.File
-		localFile, err = os.OpenFile(localFileRealPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+		localFile, err := os.OpenFile(localFileRealPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
 		if err != nil {
 			return errors.New("unable to create local file: " + err.Error())
 		}
 
 		// download the file
-		_, err = remoteFile.WriteTo(localFile)
+		modTime, err := transport.Download(vanityPath, localFile)
+		_ = localFile.Close() // error ignored; if the file could be created, it can probably be closed
 		if err != nil {
-			_ = localFile.Close()
-			return errors.New("unable to download remote file: " + err.Error())
+			return err
 		}
 
-		// close the files
-		_ = remoteFile.Close() // errors ignored; if the files could be opened/created, it can probably be closed
-		_ = localFile.Close()
-
 		// set the modification time of the local file to match the value saved from the remote file (from before the download)
 		if err = os.Chtimes(localFileRealPath, time.Now(), modTime); err != nil {
 			return errors.New("unable to set local file modification time: " + err.Error())
@@ -241,56 +109,22 @@ func sftpSync(sshClient *ssh.Client, sshEntryRoot, sshAgeDir string, sshIsWindow
 		}
 
 		// save modification time of local file
-		var fileInfo os.FileInfo
-		fileInfo, err = os.Stat(localFileRealPath)
+		fileInfo, err := os.Stat(localFileRealPath)
 		if err != nil {
 			return errors.New("unable to get local file info (mod time): " + err.Error())
 		}
-		modTime := fileInfo.ModTime()
 
 		// open local file
-		var localFile *os.File
-		localFile, err = os.Open(localFileRealPath)
+		localFile, err := os.Open(localFileRealPath)
 		if err != nil {
 			return errors.New("unable to open local file: " + err.Error())
 		}
 
-		// store path to remote entry
-		var remoteFileRealPath string
-		if isAgeFile {
-			remoteFileRealPath = getRealAgePathSFTP(vanityPath, sshAgeDir, sshIsWindows)
-		} else {
-			remoteFileRealPath = getRealPathSFTP(vanityPath, sshEntryRoot, sshIsWindows)
-		}
-
-		// create remote file
-		var remoteFile *sftp.File
-		remoteFile, err = sftpClient.OpenFile(remoteFileRealPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY)
-		if err != nil {
-			_ = localFile.Close()
-			return errors.New("unable to create remote file: " + err.Error())
-		}
-
 		// upload the file
-		_, err = localFile.WriteTo(remoteFile)
+		err = transport.Upload(vanityPath, isAgeFile, localFile, fileInfo.ModTime())
+		_ = localFile.Close() // error ignored; if the file could be opened, it can probably be closed
 		if err != nil {
-			_ = localFile.Close()
-			_ = remoteFile.Close()
-			return errors.New("unable to upload local file: " + err.Error())
-		}
-
-		// close the files
-		_ = localFile.Close() // errors ignored; if the files could be opened/created, it can probably be closed
-		_ = remoteFile.Close()
-
-		// set permissions on remote file
-		if err = sftpClient.Chmod(remoteFileRealPath, 0600); err != nil {
-			return errors.New("unable to set permissions on remote file: " + err.Error())
-		}
-
-		// set the modification time of the remote file to match the value saved from the local file (from before the upload)
-		if err = sftpClient.Chtimes(remoteFileRealPath, time.Now(), modTime); err != nil {
-			return errors.New("unable to set remote file modification time: " + err.Error())
+			return err
 		}
 	}
 
@@ -302,67 +136,215 @@ func sftpSync(sshClient *ssh.Client, sshEntryRoot, sshAgeDir string, sshIsWindow
 }
 
 // syncLists determines which entries need to be downloaded and uploaded
-// for synchronization and calls sftpSync with this information.
-func syncLists(sshClient *ssh.Client, sshEntryRoot, sshAgeDir string, sshIsWindows bool, timeSyncedErr error, localEntryMap, remoteEntryMap synccommon.EntryMapT) (*syncListsT, error) {
+// for synchronization (see getSyncDirection) and calls transferFiles with this information.
+// Once synchronized, all written entries are reported to the server's change journal (if reportWrites is true)
+// and the base state is updated for the next sync.
+func syncLists(transport TransportT, reportWrites bool, timeSyncedErr error, localEntryMap, remoteEntryMap synccommon.EntryMapT) (*syncListsT, error) {
 	// initialize slices to store entries that need to be downloaded or uploaded
 	var syncListsV syncListsT
 
+	// load the base state from the last sync and initialize the new one
+	baseState, err := loadBaseState()
+	if err != nil {
+		return nil, err
+	}
+	newBaseState := make(baseStateT)
+	pendingReconciles := make(map[string][2]synccommon.EntryT) // map vanity paths pending timestamp reconciliation to their local and remote info
+
+	// addDownload adds an entry (and its age timestamp) to the download list
+	addDownload := func(vanityPath string, remoteInfo synccommon.EntryT) error {
+		syncListsV.Download = append(syncListsV.Download, vanityPath)
+		newBaseState[vanityPath] = baseEntryT{ModTime: remoteInfo.ModTime}
+		if remoteInfo.AgeTimestamp != nil {
+			if err := age.Entry(vanityPath, *remoteInfo.AgeTimestamp); err != nil {
+				return errors.New("unable to update age timestamp for " + vanityPath + ": " + err.Error())
+			}
+		}
+		return nil
+	}
+	// addUpload adds an entry (and its age file, if needed) to the upload list
+	addUpload := func(vanityPath string, localInfo synccommon.EntryT, remoteAgeTimestamp *int64) {
+		syncListsV.Upload = append(syncListsV.Upload, vanityPath)
+		newBaseState[vanityPath] = baseEntryT{ModTime: localInfo.ModTime}
+		if localInfo.AgeTimestamp != nil && localInfo.AgeTimestamp != remoteAgeTimestamp {
+			syncListsV.Upload = append(syncListsV.Upload, global.FSMisc+vanityPath)
+		}
+	}
+
 	// iterate over client entries in local map
 	for vanityPath, localInfo := range localEntryMap {
 		// check if the entry is present on the server
 		if _, exists := remoteEntryMap[vanityPath]; exists {
-			// entry exists on both client and server, compare mod times
+			// entry exists on both client and server, compare against the base state
 			remoteInfo := remoteEntryMap[vanityPath]
-			if remoteInfo.ModTime > localInfo.ModTime {
+			if localInfo, err = addLocalHash(vanityPath, localInfo, remoteInfo); err != nil {
+				return nil, err
+			}
+			var baseInfo *baseEntryT
+			if info, hasBase := baseState[vanityPath]; hasBase {
+				baseInfo = &info
+			}
+			switch getSyncDirection(localInfo, remoteInfo, baseInfo) {
+			case syncNone:
+				newBaseState[vanityPath] = baseEntryT{ModTime: localInfo.ModTime}
+			case syncDownload:
 				fmt.Println(back.AnsiGreen+vanityPath+back.AnsiReset, "is newer on server, adding to download list")
-				syncListsV.Download = append(syncListsV.Download, vanityPath)
-				if remoteInfo.AgeTimestamp != nil {
-					if err := age.Entry(vanityPath, *remoteInfo.AgeTimestamp); err != nil {
-						return nil, errors.New("unable to update age timestamp for " + vanityPath + ": " + err.Error())
-					}
+				if err = addDownload(vanityPath, remoteInfo); err != nil {
+					return nil, err
 				}
-			} else if remoteInfo.ModTime < localInfo.ModTime {
+			case syncUpload:
 				fmt.Println(back.AnsiBlue+vanityPath+back.AnsiReset, "is newer on client, adding to upload list")
-				syncListsV.Upload = append(syncListsV.Upload, vanityPath)
-				if localInfo.AgeTimestamp != nil && localInfo.AgeTimestamp != remoteInfo.AgeTimestamp {
-					syncListsV.Upload = append(syncListsV.Upload, global.FSMisc+vanityPath)
+				addUpload(vanityPath, localInfo, remoteInfo.AgeTimestamp)
+			case syncReconcile:
+				fmt.Println(back.AnsiBold+vanityPath+back.AnsiReset, "is unchanged, adding to timestamp reconciliation list")
+				syncListsV.Reconcile = append(syncListsV.Reconcile, vanityPath)
+				pendingReconciles[vanityPath] = [2]synccommon.EntryT{localInfo, remoteInfo}
+				newBaseState[vanityPath] = baseEntryT{ModTime: max(localInfo.ModTime, remoteInfo.ModTime)}
+			case syncConflict:
+				fmt.Println(back.AnsiWarning+vanityPath+back.AnsiReset, "was changed on both client and server")
+				syncListsV.Conflict = append(syncListsV.Conflict, vanityPath)
+				resolution := ConflictSkip // conflicts are not resolved during a dry sync
+				if timeSyncedErr == nil {
+					resolution = ConflictKeepBoth
+					if ResolveConflict != nil {
+						resolution = ResolveConflict(vanityPath, localInfo, remoteInfo)
+					}
+				}
+				switch resolution {
+				case ConflictKeepBoth:
+					conflictVanityPath, err := createConflictCopy(vanityPath, localInfo)
+					if err != nil {
+						return nil, err
+					}
+					fmt.Println(back.AnsiBlue+conflictVanityPath+back.AnsiReset, "is a conflict copy of the client's version, adding to upload list")
+					addUpload(conflictVanityPath, synccommon.EntryT{ContainingFolder: localInfo.ContainingFolder, ModTime: localInfo.ModTime}, nil)
+					fmt.Println(back.AnsiGreen+vanityPath+back.AnsiReset, "is being kept from server, adding to download list")
+					if err = addDownload(vanityPath, remoteInfo); err != nil {
+						return nil, err
+					}
+				case ConflictKeepLocal:
+					// ensure the client's version is considered newer by all devices, including those without a base state
+					if localInfo.ModTime, err = touchEntry(vanityPath, remoteInfo.ModTime+1); err != nil {
+						return nil, err
+					}
+					fmt.Println(back.AnsiBlue+vanityPath+back.AnsiReset, "is being kept from client, adding to upload list")
+					addUpload(vanityPath, localInfo, remoteInfo.AgeTimestamp)
+				case ConflictKeepRemote:
+					fmt.Println(back.AnsiGreen+vanityPath+back.AnsiReset, "is being kept from server, adding to download list")
+					if err = addDownload(vanityPath, remoteInfo); err != nil {
+						return nil, err
+					}
+				default:
+					newBaseState[vanityPath] = *baseInfo // keep the entry in conflict until it is resolved
 				}
 			}
 			// remove entry from remote map (process of elimination)
 			delete(remoteEntryMap, vanityPath)
 		} else {
 			fmt.Println(back.AnsiBlue+vanityPath+back.AnsiReset, "does not exist on server, adding to upload list")
-			syncListsV.Upload = append(syncListsV.Upload, vanityPath)
-			if localInfo.AgeTimestamp != nil {
-				syncListsV.Upload = append(syncListsV.Upload, global.FSMisc+vanityPath)
-			}
+			addUpload(vanityPath, localInfo, nil)
 		}
 	}
 
 	// iterate over remaining entries in remote map
 	for vanityPath, remoteInfo := range remoteEntryMap {
 		fmt.Println(back.AnsiGreen+vanityPath+back.AnsiReset, "does not exist on client, adding to download list")
-		syncListsV.Download = append(syncListsV.Download, vanityPath)
-		if err := os.MkdirAll(global.GetRealPath(remoteInfo.ContainingFolder), 0700); err != nil {
+		if err = os.MkdirAll(global.GetRealPath(remoteInfo.ContainingFolder), 0700); err != nil {
 			return nil, errors.New("unable to create containing folder for " + vanityPath + ": " + err.Error())
 		}
-		if remoteInfo.AgeTimestamp != nil {
-			if err := age.Entry(vanityPath, *remoteInfo.AgeTimestamp); err != nil {
-				return nil, errors.New("unable to update age timestamp for " + vanityPath + ": " + err.Error())
-			}
+		if err = addDownload(vanityPath, remoteInfo); err != nil {
+			return nil, err
 		}
 	}
 
-	// call sftpSync with the download and upload lists
-	if timeSyncedErr == nil && (max(len(syncListsV.Download), len(syncListsV.Upload)) > 0) { // only call sftpSync if there are entries to download or upload
-		fmt.Println() // add a gap between list-add messages and the actual sync messages from sftpSync
-		if err := sftpSync(sshClient, sshEntryRoot, sshAgeDir, sshIsWindows, &syncListsV); err != nil {
+	// a dry sync is performed if the clocks are out of sync; the base state must not be updated
+	if timeSyncedErr != nil {
+		return &syncListsV, timeSyncedErr
+	}
+
+	// call transferFiles with the download and upload lists
+	if max(len(syncListsV.Download), len(syncListsV.Upload)) > 0 { // only call transferFiles if there are entries to download or upload
+		fmt.Println() // add a gap between list-add messages and the actual sync messages from transferFiles
+		if err = transferFiles(transport, &syncListsV); err != nil {
 			return nil, errors.New("unable to sync entries: " + err.Error())
 		}
 		fmt.Println("Client is synchronized with server")
 	}
 
-	return &syncListsV, timeSyncedErr
+	// reconcile the timestamps of unchanged entries
+	var writtenPaths []string
+	for vanityPath, info := range pendingReconciles {
+		if err = reconcileTimestamps(transport, vanityPath, info[0], info[1]); err != nil {
+			return nil, errors.New("unable to reconcile timestamps: " + err.Error())
+		}
+		if info[0].ModTime > info[1].ModTime {
+			writtenPaths = append(writtenPaths, vanityPath) // remote timestamps were updated
+		}
+	}
+
+	// report written entries to the server's change journal
+	// (if this fails, the base state is not updated, so the entries are uploaded and reported again on the next sync)
+	if reportWrites {
+		for _, vanityPath := range syncListsV.Upload {
+			if !strings.HasPrefix(vanityPath, global.FSMisc) { // age files are reported along with their entries
+				writtenPaths = append(writtenPaths, vanityPath)
+			}
+		}
+		if len(writtenPaths) > 0 {
+			if err = transport.ReportWrites(writtenPaths); err != nil {
+				return nil, errors.New("unable to report written entries to server: " + err.Error())
+			}
+		}
+	}
+
+	// save the base state for the next sync
+	if err = saveBaseState(newBaseState); err != nil {
+		return &syncListsV, err
+	}
+
+	return &syncListsV, nil
+}
+
+// reconcileTimestamps syncs the mod+age timestamps of an entry whose contents
+// are identical on the client and server, without transferring the entry itself.
+// The timestamps of whichever side has the newer mod time are kept.
+func reconcileTimestamps(transport TransportT, vanityPath string, localInfo, remoteInfo synccommon.EntryT) error {
+	if localInfo.ModTime > remoteInfo.ModTime {
+		// upload the age file if it was changed on the client
+		if localInfo.AgeTimestamp != nil && (remoteInfo.AgeTimestamp == nil || *localInfo.AgeTimestamp != *remoteInfo.AgeTimestamp) {
+			localAgeFile, err := os.Open(global.GetRealAgePath(vanityPath))
+			if err != nil {
+				return errors.New("unable to open local file: " + err.Error())
+			}
+			err = transport.Upload(vanityPath, true, localAgeFile, time.Unix(*localInfo.AgeTimestamp, 0))
+			_ = localAgeFile.Close() // error ignored; if the file could be opened, it can probably be closed
+			if err != nil {
+				return err
+			}
+		}
+		return transport.Touch(vanityPath, time.Unix(localInfo.ModTime, 0))
+	}
+	// apply the server's age timestamp
+	if remoteInfo.AgeTimestamp != nil {
+		if err := age.Entry(vanityPath, *remoteInfo.AgeTimestamp); err != nil {
+			return errors.New("unable to update age timestamp for " + vanityPath + ": " + err.Error())
+		}
+	}
+	if err := os.Chtimes(global.GetRealPath(vanityPath), time.Now(), time.Unix(remoteInfo.ModTime, 0)); err != nil {
+		return errors.New("unable to set local file modification time: " + err.Error())
+	}
+	return nil
+}
+
+// touchEntry sets the modification time of the local entry at vanityPath to
+// the current time (or minModTime, if it is later).
+// Returns: the new modification time as a UNIX timestamp.
+func touchEntry(vanityPath string, minModTime int64) (int64, error) {
+	modTime := max(time.Now().Unix(), minModTime)
+	if err := os.Chtimes(global.GetRealPath(vanityPath), time.Now(), time.Unix(modTime, 0)); err != nil {
+		return 0, errors.New("unable to set local file modification time: " + err.Error())
+	}
+	return modTime, nil
 }
 
 // deletionSync removes entries from the client that have been deleted on the server (multi-client deletion).
@@ -379,6 +361,21 @@ func deletionSync(deletions []synccommon.Deletion) error {
 			}
 			return errors.New("unable to shear age file for " + deletions[i].VanityPath + " locally: " + err.Error())
 		}
+		if !deletions[i].IsAgeFile {
+			// remove any attachments belonging to the sheared entry
+			attachmentList, err := synccommon.GetAttachmentList(deletions[i].VanityPath)
+			if err != nil {
+				return err
+			}
+			for _, attachment := range attachmentList {
+				if err = os.RemoveAll(global.GetRealPath(global.GetAttachmentVanityPath(deletions[i].VanityPath, attachment))); err != nil {
+					return errors.New("unable to shear attachment (" + attachment + ") of " + deletions[i].VanityPath + " locally: " + err.Error())
+				}
+			}
+			if err = history.Remove(deletions[i].VanityPath); err != nil {
+				return errors.New("unable to shear " + deletions[i].VanityPath + " locally: " + err.Error())
+			}
+		}
 	}
 	if entryDeleted {
 		fmt.Println() // add a gap between deletion and other messages
@@ -386,26 +383,43 @@ func deletionSync(deletions []synccommon.Deletion) error {
 	return nil
 }
 
-// RunJob runs the SSH sync job and returns deletions, downloads,
-// and uploads lists for the client to report to the user.
+// RunJob runs the sync job (using the current transport; see SetTransportProvider)
+// and returns deletions, downloads, and uploads lists for the client to report to the user.
 func RunJob() (*syncListsT, error) {
-	// get SSH client to re-use throughout the sync process
-	sshClient, offlineMode, sshIsWindows, sshEntryRoot, sshAgeDir, err := GetSSHClient()
+	// complete any interrupted entry refresh, so that local entries match the local key generation
+	if _, err := global.RecoverEntryRefresh(); err != nil {
+		return nil, err
+	}
+
+	// get transport to re-use throughout the sync process
+	transport, offlineMode, err := getTransport()
 	if offlineMode {
 		return nil, nil
 	}
 	if err != nil {
-		return nil, errors.New("unable to connect to SSH client: " + err.Error())
+		return nil, errors.New("unable to connect to server: " + err.Error())
 	}
-	defer func(sshClient *ssh.Client) {
-		_ = sshClient.Close()
-	}(sshClient)
+	defer func(transport TransportT) {
+		_ = transport.Close()
+	}(transport)
 
 	// fetch remote lists
-	remoteEntryMap, deletions, serverTime, clientTime, err := getRemoteDataFromClient(sshClient)
+	fetchResp, clientTime, err := getRemoteDataFromClient(transport)
 	if err != nil {
 		return nil, errors.New("unable to fetch remote data: " + err.Error())
 	}
+	remoteEntryMap, deletions, serverTime := fetchResp.Entries, fetchResp.Deletions, fetchResp.ServerTime
+
+	// ensure the local RCW password is current (it may have been changed on another device)
+	localKeyGeneration, err := synccommon.GetKeyGeneration()
+	if err != nil {
+		return nil, err
+	}
+	if fetchResp.KeyGeneration != nil {
+		if err = checkKeyGeneration(localKeyGeneration, fetchResp); err != nil {
+			return nil, err
+		}
+	}
 
 	// sync deletions
 	if err = deletionSync(deletions); err != nil {
@@ -427,11 +441,24 @@ func RunJob() (*syncListsT, error) {
 
 	// sync new and updated entries
 	// if time is not synced, the time sync error and upload/download lists will be returned here
-	syncListsV, err := syncLists(sshClient, *sshEntryRoot, *sshAgeDir, *sshIsWindows, timeSyncedErr, localEntryMap, remoteEntryMap)
+	syncListsV, err := syncLists(transport, fetchResp.Cursor != "", timeSyncedErr, localEntryMap, remoteEntryMap)
 	if err != nil {
 		return syncListsV, errors.New("unable to sync entries: " + err.Error())
 	}
 
+	// if the RCW password was changed on this device, inform the server (now that the re-encrypted entries have been uploaded)
+	// entries downloaded beforehand are still encrypted with the previous password, so they are re-encrypted (and uploaded) first
+	if fetchResp.KeyGeneration != nil && localKeyGeneration > *fetchResp.KeyGeneration {
+		if len(syncListsV.Download) > 0 {
+			if err = reencryptDownloads(transport, fetchResp.Sanity, syncListsV.Download, fetchResp.Cursor != ""); err != nil {
+				return syncListsV, err
+			}
+		}
+		if err = pushKeyGeneration(transport, localKeyGeneration); err != nil {
+			return syncListsV, err
+		}
+	}
+
 	// add deletions info to sync lists
 	for i := range deletions {
 		if !deletions[i].IsAgeFile {
@@ -0,0 +1,564 @@
+package syncclient
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rwinkhart/libmutton/crypt"
+	"github.com/rwinkhart/libmutton/global"
+	"github.com/rwinkhart/libmutton/synccommon"
+	"github.com/rwinkhart/rcw/wrappers"
+)
+
+// memEntryT is an entry stored by memTransportT.
+type memEntryT struct {
+	data    []byte
+	modTime time.Time
+}
+
+// memTransportT is an in-memory TransportT simulating a server without a change journal.
+type memTransportT struct {
+	entries       map[string]memEntryT
+	keyGeneration int64
+	sanity        []byte
+	clockSkew     int64 // seconds added to the reported server time
+}
+
+func (t *memTransportT) Fetch(deviceID, cursor string) (*synccommon.FetchRespT, error) {
+	entryMap := make(synccommon.EntryMapT)
+	for vanityPath, entry := range t.entries {
+		hash := sha256.Sum256(entry.data)
+		entryMap[vanityPath] = synccommon.EntryT{ContainingFolder: vanityPath[:strings.LastIndex(vanityPath, "/")], ModTime: entry.modTime.Unix(), Hash: hex.EncodeToString(hash[:])}
+	}
+	keyGeneration := t.keyGeneration
+	return &synccommon.FetchRespT{ServerTime: time.Now().Unix() + t.clockSkew, KeyGeneration: &keyGeneration, Sanity: t.sanity, Entries: entryMap}, nil
+}
+
+func (t *memTransportT) Download(vanityPath string, dst io.Writer) (time.Time, error) {
+	entry, exists := t.entries[vanityPath]
+	if !exists {
+		return time.Time{}, errors.New(vanityPath + " does not exist")
+	}
+	_, err := dst.Write(entry.data)
+	return entry.modTime, err
+}
+
+func (t *memTransportT) Upload(vanityPath string, isAgeFile bool, src io.Reader, modTime time.Time) error {
+	data, err := io.ReadAll(src)
+	if err != nil || isAgeFile {
+		return err
+	}
+	t.entries[vanityPath] = memEntryT{data: data, modTime: modTime}
+	return nil
+}
+
+func (t *memTransportT) Touch(vanityPath string, modTime time.Time) error {
+	entry, exists := t.entries[vanityPath]
+	if !exists {
+		return errors.New(vanityPath + " does not exist")
+	}
+	entry.modTime = modTime
+	t.entries[vanityPath] = entry
+	return nil
+}
+
+func (t *memTransportT) ReportWrites(vanityPaths []string) error {
+	return errors.New("server does not keep a change journal")
+}
+
+func (t *memTransportT) Shear(deviceID, vanityPath string, onlyShearAgeFile bool) error {
+	if !onlyShearAgeFile {
+		delete(t.entries, vanityPath)
+	}
+	return nil
+}
+
+func (t *memTransportT) Rename(deviceID, oldVanityPath, newVanityPath string) error {
+	t.entries[newVanityPath] = t.entries[oldVanityPath]
+	delete(t.entries, oldVanityPath)
+	return nil
+}
+
+func (t *memTransportT) AddFolder(vanityPath string) error {
+	return nil
+}
+
+func (t *memTransportT) Register(registerReq *synccommon.RegisterReqT) (*synccommon.RegisterRespT, error) {
+	return &synccommon.RegisterRespT{}, nil
+}
+
+func (t *memTransportT) Rekey(rekeyReq *synccommon.RekeyReqT) error {
+	t.keyGeneration, t.sanity = rekeyReq.KeyGeneration, rekeyReq.Sanity
+	return nil
+}
+
+func (t *memTransportT) Close() error {
+	return nil
+}
+
+// initTestClient points the libmutton directories to a temporary directory, registers deviceID,
+// and sets the transport provider to transport.
+// Returns: a function that switches back to this client (to simulate multiple devices sharing transport).
+func initTestClient(t *testing.T, transport TransportT, deviceID string) func() {
+	t.Helper()
+	root := t.TempDir()
+	use := func() {
+		global.EntryRoot = root + global.PathSeparator + "entries"
+		global.RootLength = len(global.EntryRoot)
+		global.CfgDir = root + global.PathSeparator + "cfg"
+		global.CfgPath = global.CfgDir + global.PathSeparator + "libmuttoncfg.json"
+		global.AgeDir = global.CfgDir + global.PathSeparator + "age"
+		global.HistDir = global.CfgDir + global.PathSeparator + "history"
+		SetTransportProvider(func() (TransportT, bool, error) {
+			return transport, false, nil
+		})
+	}
+	use()
+	if _, err := global.DirInit(false); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(global.CfgDir+global.PathSeparator+"devices"+global.PathSeparator+deviceID, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return use
+}
+
+// writeTestEntry writes data to the local entry at vanityPath and sets its mod time.
+func writeTestEntry(t *testing.T, vanityPath string, data []byte, modTime int64) {
+	t.Helper()
+	realPath := global.GetRealPath(vanityPath)
+	if err := os.WriteFile(realPath, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(realPath, time.Now(), time.Unix(modTime, 0)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// readTestEntry returns the contents of the local entry at vanityPath ("" if it does not exist).
+func readTestEntry(t *testing.T, vanityPath string) string {
+	t.Helper()
+	data, err := os.ReadFile(global.GetRealPath(vanityPath))
+	if err != nil && !os.IsNotExist(err) {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+// runTestJob runs RunJob and fails the test on error.
+func runTestJob(t *testing.T) *syncListsT {
+	t.Helper()
+	syncListsV, err := RunJob()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return syncListsV
+}
+
+// setTestConflictResolver sets ResolveConflict for the duration of the test.
+func setTestConflictResolver(t *testing.T, resolve func(vanityPath string, localInfo, remoteInfo synccommon.EntryT) ConflictResolutionT) {
+	ResolveConflict = resolve
+	t.Cleanup(func() {
+		ResolveConflict = nil
+	})
+}
+
+func TestRunJobRoundTrip(t *testing.T) {
+	remoteModTime := time.Unix(time.Now().Unix()-3600, 0)
+	transport := &memTransportT{entries: map[string]memEntryT{"/remote": {data: []byte("remote"), modTime: remoteModTime}}}
+	initTestClient(t, transport, "test-device")
+	if err := os.WriteFile(global.GetRealPath("/local"), []byte("local"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// the local entry is uploaded and the remote entry is downloaded
+	syncListsV, err := RunJob()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(syncListsV.Upload, []string{"/local"}) || !slices.Equal(syncListsV.Download, []string{"/remote"}) {
+		t.Fatalf("unexpected sync lists: upload %v, download %v", syncListsV.Upload, syncListsV.Download)
+	}
+	if !bytes.Equal(transport.entries["/local"].data, []byte("local")) {
+		t.Fatal("local entry was not uploaded")
+	}
+	downloaded, err := os.ReadFile(global.GetRealPath("/remote"))
+	if err != nil || !bytes.Equal(downloaded, []byte("remote")) {
+		t.Fatal("remote entry was not downloaded")
+	}
+	if fileInfo, err := os.Stat(global.GetRealPath("/remote")); err != nil || !fileInfo.ModTime().Equal(remoteModTime) {
+		t.Fatal("downloaded entry does not have the remote mod time")
+	}
+
+	// once synchronized, nothing is transferred
+	if syncListsV, err = RunJob(); err != nil {
+		t.Fatal(err)
+	}
+	if len(syncListsV.Upload) > 0 || len(syncListsV.Download) > 0 || len(syncListsV.Conflict) > 0 {
+		t.Fatalf("unexpected sync lists after synchronization: %+v", syncListsV)
+	}
+}
+
+func TestGetSyncDirection(t *testing.T) {
+	tests := []struct {
+		name          string
+		localModTime  int64
+		localHash     string
+		remoteModTime int64
+		remoteHash    string
+		baseModTime   int64 // 0 = no base state
+		wantDirection uint8
+	}{
+		{"unchanged", 100, "a", 100, "b", 100, syncNone},
+		{"unchanged without base", 100, "a", 100, "a", 0, syncNone},
+		{"identical contents", 100, "a", 200, "a", 50, syncReconcile},
+		{"identical contents without base", 200, "a", 100, "a", 0, syncReconcile},
+		{"unknown hashes", 100, "", 200, "", 0, syncDownload},
+		{"newer on server without base", 100, "a", 200, "b", 0, syncDownload},
+		{"newer on client without base", 200, "a", 100, "b", 0, syncUpload},
+		{"changed on server", 100, "a", 200, "b", 100, syncDownload},
+		{"changed on server to an older mod time", 100, "a", 50, "b", 100, syncDownload},
+		{"changed on client", 200, "a", 100, "b", 100, syncUpload},
+		{"changed on client to an older mod time", 50, "a", 100, "b", 100, syncUpload},
+		{"changed on both", 200, "a", 300, "b", 100, syncConflict},
+		{"changed on both (client newer)", 300, "a", 200, "b", 100, syncConflict},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var baseInfo *baseEntryT
+			if test.baseModTime != 0 {
+				baseInfo = &baseEntryT{ModTime: test.baseModTime}
+			}
+			localInfo := synccommon.EntryT{ModTime: test.localModTime, Hash: test.localHash}
+			remoteInfo := synccommon.EntryT{ModTime: test.remoteModTime, Hash: test.remoteHash}
+			if direction := getSyncDirection(localInfo, remoteInfo, baseInfo); direction != test.wantDirection {
+				t.Fatalf("got direction %d; want %d", direction, test.wantDirection)
+			}
+		})
+	}
+}
+
+func TestRunJobResolvesConflicts(t *testing.T) {
+	tests := []struct {
+		name              string
+		resolution        ConflictResolutionT
+		wantLocal         string
+		wantRemote        string
+		wantCopy          bool // a conflict copy of the client's version is synced
+		wantConflictAgain bool // the conflict is reported again on the next sync
+	}{
+		{"keep both", ConflictKeepBoth, "remote", "remote", true, false},
+		{"keep local", ConflictKeepLocal, "local", "local", false, false},
+		{"keep remote", ConflictKeepRemote, "remote", "remote", false, false},
+		{"skip", ConflictSkip, "local", "remote", false, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			transport := &memTransportT{entries: map[string]memEntryT{}}
+			initTestClient(t, transport, "test-device")
+			baseModTime := time.Now().Unix() - 3600
+			writeTestEntry(t, "/entry", []byte("base"), baseModTime)
+			runTestJob(t)
+
+			// change the entry on the client and (as if by another device) on the server
+			writeTestEntry(t, "/entry", []byte("local"), baseModTime+100)
+			transport.entries["/entry"] = memEntryT{data: []byte("remote"), modTime: time.Unix(baseModTime+200, 0)}
+			var resolverCalls int
+			setTestConflictResolver(t, func(vanityPath string, localInfo, remoteInfo synccommon.EntryT) ConflictResolutionT {
+				resolverCalls++
+				if vanityPath != "/entry" || localInfo.ModTime != baseModTime+100 || remoteInfo.ModTime != baseModTime+200 {
+					t.Errorf("unexpected conflict: %s (local %+v, remote %+v)", vanityPath, localInfo, remoteInfo)
+				}
+				return test.resolution
+			})
+			syncListsV := runTestJob(t)
+			if resolverCalls != 1 || !slices.Equal(syncListsV.Conflict, []string{"/entry"}) {
+				t.Fatalf("conflict was not resolved exactly once: %d calls, conflicts %v", resolverCalls, syncListsV.Conflict)
+			}
+			if local := readTestEntry(t, "/entry"); local != test.wantLocal {
+				t.Errorf("local entry is %q; want %q", local, test.wantLocal)
+			}
+			if remote := string(transport.entries["/entry"].data); remote != test.wantRemote {
+				t.Errorf("remote entry is %q; want %q", remote, test.wantRemote)
+			}
+			conflictVanityPath := getConflictVanityPath("/entry", "test-device", baseModTime+100)
+			_, copyUploaded := transport.entries[conflictVanityPath]
+			if copyUploaded != test.wantCopy || (test.wantCopy && readTestEntry(t, conflictVanityPath) != "local") {
+				t.Errorf("conflict copy uploaded: %t; want %t", copyUploaded, test.wantCopy)
+			}
+
+			// once resolved, the entry is synchronized; skipped conflicts are reported again
+			resolverCalls = 0
+			syncListsV = runTestJob(t)
+			if len(syncListsV.Upload) > 0 || len(syncListsV.Download) > 0 {
+				t.Fatalf("unexpected transfers after resolution: %+v", syncListsV)
+			}
+			if conflictAgain := len(syncListsV.Conflict) > 0; conflictAgain != test.wantConflictAgain || resolverCalls != len(syncListsV.Conflict) {
+				t.Fatalf("unexpected conflicts after resolution: %v (%d resolver calls)", syncListsV.Conflict, resolverCalls)
+			}
+		})
+	}
+}
+
+func TestRunJobTwoDeviceConflict(t *testing.T) {
+	transport := &memTransportT{entries: map[string]memEntryT{}}
+	useDeviceA := initTestClient(t, transport, "device-a")
+	useDeviceB := initTestClient(t, transport, "device-b")
+	baseModTime := time.Now().Unix() - 3600
+
+	// device A creates the entry and device B downloads it
+	useDeviceA()
+	writeTestEntry(t, "/entry", []byte("base"), baseModTime)
+	runTestJob(t)
+	useDeviceB()
+	if syncListsV := runTestJob(t); !slices.Equal(syncListsV.Download, []string{"/entry"}) {
+		t.Fatalf("device B did not download the entry: %+v", syncListsV)
+	}
+
+	// both devices change the entry; device A syncs first, so its version is uploaded
+	useDeviceA()
+	writeTestEntry(t, "/entry", []byte("device A"), baseModTime+100)
+	useDeviceB()
+	writeTestEntry(t, "/entry", []byte("device B"), baseModTime+200) // newer, but still a conflict
+	useDeviceA()
+	if syncListsV := runTestJob(t); !slices.Equal(syncListsV.Upload, []string{"/entry"}) {
+		t.Fatalf("device A did not upload its change: %+v", syncListsV)
+	}
+
+	// device B keeps device A's version and uploads its own as a conflict copy (the default resolution)
+	useDeviceB()
+	conflictVanityPath := getConflictVanityPath("/entry", "device-b", baseModTime+200)
+	syncListsV := runTestJob(t)
+	if !slices.Equal(syncListsV.Conflict, []string{"/entry"}) || !slices.Equal(syncListsV.Upload, []string{conflictVanityPath}) {
+		t.Fatalf("unexpected sync lists for device B: %+v", syncListsV)
+	}
+
+	// device A downloads the conflict copy; both devices then hold the same entries
+	useDeviceA()
+	if syncListsV = runTestJob(t); !slices.Equal(syncListsV.Download, []string{conflictVanityPath}) || len(syncListsV.Conflict) > 0 {
+		t.Fatalf("unexpected sync lists for device A: %+v", syncListsV)
+	}
+	for _, useDevice := range []func(){useDeviceA, useDeviceB} {
+		useDevice()
+		if entry, conflictCopy := readTestEntry(t, "/entry"), readTestEntry(t, conflictVanityPath); entry != "device A" || conflictCopy != "device B" {
+			t.Fatalf("unexpected entries on %s: entry %q, conflict copy %q", global.CfgDir, entry, conflictCopy)
+		}
+		conflicts, err := ListConflicts()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(conflicts) != 1 || conflicts[0].OriginalVanityPath != "/entry" || conflicts[0].DeviceID != "device-b" {
+			t.Fatalf("unexpected conflicts on %s: %+v", global.CfgDir, conflicts)
+		}
+		if syncListsV = runTestJob(t); len(syncListsV.Upload) > 0 || len(syncListsV.Download) > 0 || len(syncListsV.Conflict) > 0 {
+			t.Fatalf("unexpected sync lists after synchronization: %+v", syncListsV)
+		}
+	}
+}
+
+func TestRunJobDrySync(t *testing.T) {
+	transport := &memTransportT{entries: map[string]memEntryT{}}
+	initTestClient(t, transport, "test-device")
+	baseModTime := time.Now().Unix() - 3600
+	writeTestEntry(t, "/entry", []byte("base"), baseModTime)
+	runTestJob(t)
+	baseStateBytes, err := os.ReadFile(getBaseStatePath())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// with the clocks out of sync, changes are listed but not transferred and conflicts are not resolved
+	writeTestEntry(t, "/entry", []byte("local"), baseModTime+100)
+	transport.entries["/entry"] = memEntryT{data: []byte("remote"), modTime: time.Unix(baseModTime+200, 0)}
+	transport.entries["/new"] = memEntryT{data: []byte("new"), modTime: time.Unix(baseModTime+200, 0)}
+	transport.clockSkew = 3600
+	setTestConflictResolver(t, func(vanityPath string, localInfo, remoteInfo synccommon.EntryT) ConflictResolutionT {
+		t.Error("conflict resolver called during a dry sync")
+		return ConflictKeepLocal
+	})
+	syncListsV, err := RunJob()
+	if err == nil {
+		t.Fatal("dry sync did not return an error")
+	}
+	if !slices.Equal(syncListsV.Conflict, []string{"/entry"}) || !slices.Equal(syncListsV.Download, []string{"/new"}) {
+		t.Fatalf("unexpected dry sync lists: %+v", syncListsV)
+	}
+	if readTestEntry(t, "/entry") != "local" || readTestEntry(t, "/new") != "" || string(transport.entries["/entry"].data) != "remote" {
+		t.Fatal("entries were transferred during a dry sync")
+	}
+	if newBaseStateBytes, err := os.ReadFile(getBaseStatePath()); err != nil || !bytes.Equal(newBaseStateBytes, baseStateBytes) {
+		t.Fatal("base state was changed by a dry sync")
+	}
+}
+
+func TestAddLocalHash(t *testing.T) {
+	initTestClient(t, &memTransportT{entries: map[string]memEntryT{}}, "test-device")
+	writeTestEntry(t, "/entry", []byte("entry"), 100)
+	hash := sha256.Sum256([]byte("entry"))
+	tests := []struct {
+		name          string
+		vanityPath    string
+		localModTime  int64
+		remoteModTime int64
+		remoteHash    string
+		wantHash      string
+	}{
+		{"mod times differ", "/entry", 100, 200, "remote", hex.EncodeToString(hash[:])},
+		{"mod times match", "/missing", 100, 100, "remote", ""}, // not hashed, so the missing entry is not an error
+		{"remote hash unknown", "/missing", 100, 200, "", ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			localInfo, err := addLocalHash(test.vanityPath, synccommon.EntryT{ModTime: test.localModTime}, synccommon.EntryT{ModTime: test.remoteModTime, Hash: test.remoteHash})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if localInfo.Hash != test.wantHash {
+				t.Fatalf("got hash %q; want %q", localInfo.Hash, test.wantHash)
+			}
+		})
+	}
+}
+
+func TestRunJobReconcilesIdenticalEntries(t *testing.T) {
+	baseModTime := time.Now().Unix() - 3600
+	transport := &memTransportT{entries: map[string]memEntryT{
+		"/newer-on-server": {data: []byte("server"), modTime: time.Unix(baseModTime+100, 0)},
+		"/newer-on-client": {data: []byte("client"), modTime: time.Unix(baseModTime, 0)},
+	}}
+	initTestClient(t, transport, "test-device")
+	writeTestEntry(t, "/newer-on-server", []byte("server"), baseModTime)
+	writeTestEntry(t, "/newer-on-client", []byte("client"), baseModTime+100)
+
+	// identical entries are not transferred; only the newer mod time is kept on both sides
+	syncListsV := runTestJob(t)
+	slices.Sort(syncListsV.Reconcile)
+	if len(syncListsV.Upload) > 0 || len(syncListsV.Download) > 0 || !slices.Equal(syncListsV.Reconcile, []string{"/newer-on-client", "/newer-on-server"}) {
+		t.Fatalf("unexpected sync lists: %+v", syncListsV)
+	}
+	for _, vanityPath := range syncListsV.Reconcile {
+		fileInfo, err := os.Stat(global.GetRealPath(vanityPath))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if localModTime, remoteModTime := fileInfo.ModTime().Unix(), transport.entries[vanityPath].modTime.Unix(); localModTime != baseModTime+100 || remoteModTime != baseModTime+100 {
+			t.Fatalf("%s was not reconciled: local mod time %d, remote mod time %d", vanityPath, localModTime, remoteModTime)
+		}
+	}
+	if syncListsV = runTestJob(t); len(syncListsV.Reconcile) > 0 {
+		t.Fatalf("unexpected sync lists after reconciliation: %+v", syncListsV)
+	}
+}
+
+// setTestPasswords answers the RCW password prompts with previousPassword (for "Previous RCW Password:")
+// or currentPassword (for any other prompt) for the duration of the test.
+func setTestPasswords(t *testing.T, previousPassword, currentPassword string) {
+	getPassword, retryPassword := global.GetPassword, crypt.RetryPassword
+	global.GetPassword = func(prompt string) []byte {
+		if prompt == "Previous RCW Password:" {
+			return []byte(previousPassword)
+		}
+		return []byte(currentPassword)
+	}
+	crypt.RetryPassword = false // fail instead of prompting forever
+	t.Cleanup(func() {
+		global.GetPassword, crypt.RetryPassword = getPassword, retryPassword
+	})
+}
+
+// genTestSanity returns an RCW sanity check file for password.
+func genTestSanity(t *testing.T, password string) []byte {
+	t.Helper()
+	sanityPath := t.TempDir() + global.PathSeparator + "sanity.rcw"
+	if err := wrappers.GenSanityCheck(sanityPath, []byte(password), false); err != nil {
+		t.Fatal(err)
+	}
+	sanity, err := os.ReadFile(sanityPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sanity
+}
+
+// encryptTestEntry returns data encrypted with password.
+func encryptTestEntry(data, password string) []byte {
+	return wrappers.Encrypt([]byte(data), []byte(password), false, false)
+}
+
+// assertTestEntryPassword fails the test unless encBytes decrypts to data with password.
+func assertTestEntryPassword(t *testing.T, encBytes []byte, data, password string) {
+	t.Helper()
+	decBytes, err := wrappers.Decrypt(encBytes, []byte(password), false)
+	if err != nil || string(decBytes) != data {
+		t.Fatalf("entry is not encrypted with %q (%v)", password, err)
+	}
+}
+
+func TestRunJobAdoptsKeyGeneration(t *testing.T) {
+	if testing.Short() {
+		t.Skip("RCW key derivation is slow")
+	}
+	// the RCW password was changed to "new" on another device
+	transport := &memTransportT{entries: map[string]memEntryT{}, keyGeneration: 1, sanity: genTestSanity(t, "new")}
+	initTestClient(t, transport, "test-device")
+	sanityPath := global.CfgDir + global.PathSeparator + "sanity.rcw"
+	if err := os.WriteFile(sanityPath, genTestSanity(t, "old"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	writeTestEntry(t, "/local", encryptTestEntry("local", "old"), time.Now().Unix()-3600)
+	setTestPasswords(t, "old", "new")
+
+	// the local change is re-encrypted with the new password before it is uploaded
+	if syncListsV := runTestJob(t); !slices.Equal(syncListsV.Upload, []string{"/local"}) {
+		t.Fatalf("unexpected sync lists: %+v", syncListsV)
+	}
+	assertTestEntryPassword(t, transport.entries["/local"].data, "local", "new")
+	if keyGeneration, err := synccommon.GetKeyGeneration(); err != nil || keyGeneration != 1 {
+		t.Fatalf("key generation was not adopted: %d (%v)", keyGeneration, err)
+	}
+	if sanity, err := os.ReadFile(sanityPath); err != nil || !bytes.Equal(sanity, transport.sanity) {
+		t.Fatal("sanity check file was not replaced")
+	}
+}
+
+func TestRunJobReencryptsDownloadsBeforeRekey(t *testing.T) {
+	if testing.Short() {
+		t.Skip("RCW key derivation is slow")
+	}
+	// the RCW password was changed to "new" on this device, while another device uploaded an entry with the old one
+	remoteModTime := time.Unix(time.Now().Unix()-3600, 0)
+	transport := &memTransportT{
+		entries:       map[string]memEntryT{"/remote": {data: encryptTestEntry("remote", "old"), modTime: remoteModTime}},
+		keyGeneration: 0,
+		sanity:        genTestSanity(t, "old"),
+	}
+	initTestClient(t, transport, "test-device")
+	sanity := genTestSanity(t, "new")
+	if err := os.WriteFile(global.CfgDir+global.PathSeparator+"sanity.rcw", sanity, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := synccommon.SetKeyGeneration(1); err != nil {
+		t.Fatal(err)
+	}
+	setTestPasswords(t, "old", "new")
+
+	// the downloaded entry is re-encrypted and uploaded again before the new key generation is pushed
+	if syncListsV := runTestJob(t); !slices.Equal(syncListsV.Download, []string{"/remote"}) {
+		t.Fatalf("unexpected sync lists: %+v", syncListsV)
+	}
+	if !transport.entries["/remote"].modTime.After(remoteModTime) {
+		t.Fatal("re-encrypted entry is not newer than the downloaded version")
+	}
+	assertTestEntryPassword(t, transport.entries["/remote"].data, "remote", "new")
+	if transport.keyGeneration != 1 || !bytes.Equal(transport.sanity, sanity) {
+		t.Fatal("new key generation was not pushed")
+	}
+}
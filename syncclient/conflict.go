@@ -0,0 +1,101 @@
+package syncclient
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/rwinkhart/libmutton/global"
+	"github.com/rwinkhart/libmutton/synccommon"
+)
+
+// Conflict copies are regular entries (or attachments) named after the entry they conflict with:
+// "<vanity path> (conflict <device ID> <timestamp>)", where the device ID is that of the device
+// the conflicting version was made on and the timestamp is its modification time (local time, conflictTimeFormat).
+// They are synced like any other entry, so they appear on every device until they are merged and removed.
+const (
+	conflictMarker     = " (conflict "
+	conflictTimeFormat = "20060102-150405"
+)
+
+// ConflictT describes a conflict copy created by RunJob (see ListConflicts).
+type ConflictT struct {
+	VanityPath         string    // vanity path of the conflict copy
+	OriginalVanityPath string    // vanity path of the entry the conflict copy was made from
+	DeviceID           string    // device ID of the device the conflicting version was made on
+	ModTime            time.Time // modification time of the conflicting version
+}
+
+// getConflictVanityPath returns the vanity path of a conflict copy of the entry at vanityPath.
+func getConflictVanityPath(vanityPath, deviceID string, modTime int64) string {
+	return vanityPath + conflictMarker + deviceID + " " + time.Unix(modTime, 0).Format(conflictTimeFormat) + ")"
+}
+
+// parseConflictVanityPath parses the vanity path of a conflict copy.
+// Returns: the conflict's details and whether vanityPath is a conflict copy.
+func parseConflictVanityPath(vanityPath string) (ConflictT, bool) {
+	markerIndex := strings.LastIndex(vanityPath, conflictMarker)
+	if markerIndex == -1 || !strings.HasSuffix(vanityPath, ")") || strings.Contains(vanityPath[markerIndex:], "/") {
+		return ConflictT{}, false
+	}
+	details := vanityPath[markerIndex+len(conflictMarker) : len(vanityPath)-1]
+	spaceIndex := strings.LastIndex(details, " ")
+	if spaceIndex < 1 {
+		return ConflictT{}, false
+	}
+	modTime, err := time.ParseInLocation(conflictTimeFormat, details[spaceIndex+1:], time.Local)
+	if err != nil {
+		return ConflictT{}, false
+	}
+	return ConflictT{
+		VanityPath:         vanityPath,
+		OriginalVanityPath: vanityPath[:markerIndex],
+		DeviceID:           details[:spaceIndex],
+		ModTime:            modTime,
+	}, true
+}
+
+// ListConflicts returns all conflict copies (including those of attachments) in the entry directory.
+// Once a conflict copy has been merged by hand, it should be sheared like any other entry.
+func ListConflicts() ([]ConflictT, error) {
+	entries, _, err := synccommon.WalkEntryDir()
+	if err != nil {
+		return nil, errors.New("unable to walk entry directory: " + err.Error())
+	}
+	var conflicts []ConflictT
+	for _, vanityPath := range entries {
+		if conflict, isConflict := parseConflictVanityPath(vanityPath); isConflict {
+			conflicts = append(conflicts, conflict)
+		}
+	}
+	return conflicts, nil
+}
+
+// createConflictCopy copies the local version of the entry at vanityPath
+// to a conflict copy (see getConflictVanityPath), preserving its modification time.
+// Returns: the vanity path of the conflict copy.
+func createConflictCopy(vanityPath string, localInfo synccommon.EntryT) (string, error) {
+	deviceIDList, err := global.GenDeviceIDList()
+	if err != nil {
+		return "", err
+	}
+	if len(deviceIDList) == 0 {
+		return "", errors.New("no device ID found")
+	}
+	conflictVanityPath := getConflictVanityPath(vanityPath, deviceIDList[0].Name(), localInfo.ModTime)
+	conflictRealPath := global.GetRealPath(conflictVanityPath)
+
+	encBytes, err := os.ReadFile(global.GetRealPath(vanityPath))
+	if err != nil {
+		return "", errors.New("unable to read \"" + vanityPath + "\": " + err.Error())
+	}
+	if err = os.WriteFile(conflictRealPath, encBytes, 0600); err != nil {
+		return "", errors.New("unable to write conflict copy of \"" + vanityPath + "\": " + err.Error())
+	}
+	modTime := time.Unix(localInfo.ModTime, 0)
+	if err = os.Chtimes(conflictRealPath, time.Now(), modTime); err != nil {
+		return "", errors.New("unable to set conflict copy modification time: " + err.Error())
+	}
+	return conflictVanityPath, nil
+}
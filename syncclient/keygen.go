@@ -0,0 +1,272 @@
+package syncclient
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/rwinkhart/go-boilerplate/back"
+	"github.com/rwinkhart/go-boilerplate/security"
+	"github.com/rwinkhart/libmutton/crypt"
+	"github.com/rwinkhart/libmutton/global"
+	"github.com/rwinkhart/libmutton/synccommon"
+	"github.com/rwinkhart/rcw/daemon"
+	"github.com/rwinkhart/rcw/wrappers"
+)
+
+// checkKeyGeneration compares the local key generation with the server's.
+// If the RCW password was changed on another device, the user is walked through
+// adopting the new password (see adoptKeyGeneration) before any entries are synced.
+func checkKeyGeneration(localKeyGeneration int64, fetchResp *synccommon.FetchRespT) error {
+	remoteKeyGeneration := *fetchResp.KeyGeneration
+	if remoteKeyGeneration > localKeyGeneration {
+		return adoptKeyGeneration(remoteKeyGeneration, fetchResp.Sanity, fetchResp.Entries)
+	}
+	if remoteKeyGeneration == localKeyGeneration && remoteKeyGeneration > 0 {
+		// the sanity check file is copied as-is between devices, so it must match for the same key generation
+		localSanity, err := os.ReadFile(global.CfgDir + global.PathSeparator + "sanity.rcw")
+		if err != nil {
+			return errors.New("unable to read sanity check file: " + err.Error())
+		}
+		if !bytes.Equal(localSanity, fetchResp.Sanity) {
+			return errors.New("the RCW password was changed on multiple devices at once; change it again on this device to resolve the conflict")
+		}
+	}
+	return nil
+}
+
+// adoptKeyGeneration prompts the user for the RCW password that was set on another device
+// (verified against the server's sanity check file), re-encrypts all local changes pending upload
+// (and the local revision history) with it, and replaces the local sanity check file.
+// Local entries pending download are left as-is, as they will be replaced by re-encrypted versions from the server.
+// It is refused while an RCW daemon is running, as the daemon would keep encrypting with the previous password.
+func adoptKeyGeneration(remoteKeyGeneration int64, remoteSanity []byte, remoteEntryMap synccommon.EntryMapT) error {
+	if daemon.IsOpen() {
+		return errors.New("the RCW password was changed on another device; stop the running RCW daemon and sync again to adopt it")
+	}
+	sanityPath := global.CfgDir + global.PathSeparator + "sanity.rcw"
+	if err := os.WriteFile(sanityPath+"-remote", remoteSanity, 0600); err != nil {
+		return errors.New("unable to write remote sanity check file: " + err.Error())
+	}
+	defer func(path string) {
+		_ = os.RemoveAll(path)
+	}(sanityPath + "-remote")
+
+	fmt.Println(back.AnsiBlue + "The RCW password was changed on another device" + back.AnsiReset)
+	newRCWPassword, err := getVerifiedPassword("New RCW Password:", runSanityCheck(sanityPath+"-remote"))
+	if err != nil {
+		return err
+	}
+	defer security.ZeroizeBytes(newRCWPassword)
+
+	// collect local changes pending upload (encrypted with the previous password),
+	// including conflicts, as the client's version may be kept (see syncLists)
+	localEntryMap, err := synccommon.GetAllEntryData()
+	if err != nil {
+		return errors.New("unable to fetch local entry data: " + err.Error())
+	}
+	baseState, err := loadBaseState()
+	if err != nil {
+		return err
+	}
+	var pendingRealPaths []string
+	for vanityPath, localInfo := range localEntryMap {
+		remoteInfo, exists := remoteEntryMap[vanityPath]
+		if exists {
+			var baseInfo *baseEntryT
+			if info, hasBase := baseState[vanityPath]; hasBase {
+				baseInfo = &info
+			}
+			if localInfo, err = addLocalHash(vanityPath, localInfo, remoteInfo); err != nil {
+				return err
+			}
+			if direction := getSyncDirection(localInfo, remoteInfo, baseInfo); direction != syncUpload && direction != syncConflict {
+				continue
+			}
+		}
+		pendingRealPaths = append(pendingRealPaths, global.GetRealPath(vanityPath))
+	}
+	var histRealPaths []string
+	err = filepath.WalkDir(global.HistDir, func(realPath string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if !entry.IsDir() {
+			histRealPaths = append(histRealPaths, realPath)
+		}
+		return nil
+	})
+	if err != nil {
+		return errors.New("unable to walk revision history directory: " + err.Error())
+	}
+
+	// re-encrypt local changes and revision history
+	if len(pendingRealPaths) > 0 || len(histRealPaths) > 0 {
+		oldRCWPassword, err := getVerifiedPassword("Previous RCW Password:", runSanityCheck(sanityPath))
+		if err != nil {
+			return err
+		}
+		defer security.ZeroizeBytes(oldRCWPassword)
+		if err = reencryptInPlace(pendingRealPaths, oldRCWPassword, newRCWPassword, false); err != nil {
+			return err
+		}
+		if err = reencryptInPlace(histRealPaths, oldRCWPassword, newRCWPassword, true); err != nil {
+			return errors.New("unable to re-encrypt revision history: " + err.Error())
+		}
+	}
+
+	// adopt the new key generation
+	if err = os.Rename(sanityPath+"-remote", sanityPath); err != nil {
+		return errors.New("unable to replace sanity check file: " + err.Error())
+	}
+	if err = synccommon.SetKeyGeneration(remoteKeyGeneration); err != nil {
+		return err
+	}
+	fmt.Println(back.AnsiBlue + "The new RCW password has been adopted" + back.AnsiReset)
+	return nil
+}
+
+// getVerifiedPassword prompts the user for a password until it passes verify (e.g. see runSanityCheck).
+// If crypt.RetryPassword is false, an incorrect password is returned as an error instead.
+func getVerifiedPassword(prompt string, verify func(password []byte) error) ([]byte, error) {
+	for {
+		password := global.GetPassword(prompt)
+		if err := verify(password); err == nil {
+			return password, nil
+		}
+		security.ZeroizeBytes(password)
+		if !crypt.RetryPassword {
+			return nil, errors.New("incorrect password")
+		}
+		fmt.Println(back.AnsiError + "Incorrect password" + back.AnsiReset)
+	}
+}
+
+// runSanityCheck returns a function that runs the sanity check at sanityPath (for use with getVerifiedPassword).
+func runSanityCheck(sanityPath string) func(password []byte) error {
+	return func(password []byte) error {
+		return wrappers.RunSanityCheck(sanityPath, password)
+	}
+}
+
+// reencryptInPlace re-encrypts each file with newRCWPassword, preserving its mod time (so that pending uploads remain pending).
+// Files that are already encrypted with newRCWPassword (e.g. from an interrupted previous attempt) are skipped.
+// If skipUndecryptable is true, files that cannot be decrypted with either password are also skipped.
+func reencryptInPlace(realPaths []string, oldRCWPassword, newRCWPassword []byte, skipUndecryptable bool) error {
+	tmpPath := global.CfgDir + global.PathSeparator + "rekey.tmp"
+	for _, realPath := range realPaths {
+		fileInfo, err := os.Stat(realPath)
+		if err != nil {
+			return errors.New("unable to get file info (mod time): " + err.Error())
+		}
+		encBytes, err := os.ReadFile(realPath)
+		if err != nil {
+			return errors.New("unable to open \"" + realPath + "\" for decryption: " + err.Error())
+		}
+		decBytes, err := wrappers.Decrypt(encBytes, oldRCWPassword, false)
+		if err != nil {
+			if _, newErr := wrappers.Decrypt(encBytes, newRCWPassword, false); newErr == nil || skipUndecryptable {
+				continue
+			}
+			return errors.New("unable to decrypt \"" + realPath + "\": " + err.Error())
+		}
+		// write to a temporary file first so that a crash cannot corrupt the original
+		if err = os.WriteFile(tmpPath, wrappers.Encrypt(decBytes, newRCWPassword, true, false), 0600); err != nil {
+			return errors.New("unable to write to file: " + err.Error())
+		}
+		if err = os.Chtimes(tmpPath, time.Now(), fileInfo.ModTime()); err != nil {
+			return errors.New("unable to set file modification time: " + err.Error())
+		}
+		if err = os.Rename(tmpPath, realPath); err != nil {
+			return errors.New("unable to replace \"" + realPath + "\": " + err.Error())
+		}
+	}
+	return nil
+}
+
+// reencryptDownloads re-encrypts entries that were just downloaded from a server that has not yet received
+// the RCW password changed on this device (see pushKeyGeneration), as they are still encrypted with the previous
+// password. The re-encrypted entries are then uploaded again with a new mod time, so that other devices
+// replace their copies (encrypted with the previous password) on their next sync.
+// remoteSanity is the server's sanity check file (nil if the RCW password has never been changed on the server,
+// in which case the previous password is verified by decrypting the first downloaded entry instead).
+func reencryptDownloads(transport TransportT, remoteSanity []byte, vanityPaths []string, reportWrites bool) error {
+	sanityPath := global.CfgDir + global.PathSeparator + "sanity.rcw"
+	verifyOld := func(password []byte) error {
+		encBytes, err := os.ReadFile(global.GetRealPath(vanityPaths[0]))
+		if err != nil {
+			return err
+		}
+		_, err = wrappers.Decrypt(encBytes, password, false)
+		return err
+	}
+	if remoteSanity != nil {
+		if err := os.WriteFile(sanityPath+"-remote", remoteSanity, 0600); err != nil {
+			return errors.New("unable to write remote sanity check file: " + err.Error())
+		}
+		defer func(path string) {
+			_ = os.RemoveAll(path)
+		}(sanityPath + "-remote")
+		verifyOld = runSanityCheck(sanityPath + "-remote")
+	}
+
+	fmt.Println(back.AnsiBlue + "Downloaded entries are encrypted with the previous RCW password and must be re-encrypted" + back.AnsiReset)
+	oldRCWPassword, err := getVerifiedPassword("Previous RCW Password:", verifyOld)
+	if err != nil {
+		return err
+	}
+	defer security.ZeroizeBytes(oldRCWPassword)
+	newRCWPassword, err := getVerifiedPassword("RCW Password:", runSanityCheck(sanityPath))
+	if err != nil {
+		return err
+	}
+	defer security.ZeroizeBytes(newRCWPassword)
+
+	realPaths := make([]string, len(vanityPaths))
+	for i, vanityPath := range vanityPaths {
+		realPaths[i] = global.GetRealPath(vanityPath)
+	}
+	if err = reencryptInPlace(realPaths, oldRCWPassword, newRCWPassword, false); err != nil {
+		return err
+	}
+
+	// upload the re-encrypted entries (newer than the downloaded versions, so other devices download them)
+	for i, vanityPath := range vanityPaths {
+		fileInfo, err := os.Stat(realPaths[i])
+		if err != nil {
+			return errors.New("unable to get file info (mod time): " + err.Error())
+		}
+		if _, err = touchEntry(vanityPath, fileInfo.ModTime().Unix()+1); err != nil {
+			return err
+		}
+	}
+	if err = transferFiles(transport, &syncListsT{Upload: vanityPaths}); err != nil {
+		return errors.New("unable to upload re-encrypted entries: " + err.Error())
+	}
+	if reportWrites {
+		if err = transport.ReportWrites(vanityPaths); err != nil {
+			return errors.New("unable to report written entries to server: " + err.Error())
+		}
+	}
+	return nil
+}
+
+// pushKeyGeneration sends the local key generation and sanity check file to the server
+// (after the RCW password was changed on this device), so that other devices adopt it.
+func pushKeyGeneration(transport TransportT, localKeyGeneration int64) error {
+	sanity, err := os.ReadFile(global.CfgDir + global.PathSeparator + "sanity.rcw")
+	if err != nil {
+		return errors.New("unable to read sanity check file: " + err.Error())
+	}
+	if err = transport.Rekey(&synccommon.RekeyReqT{KeyGeneration: localKeyGeneration, Sanity: sanity}); err != nil {
+		return errors.New("unable to send new key generation to server: " + err.Error())
+	}
+	return nil
+}
@@ -1,7 +1,6 @@
 package syncclient
 
 import (
-	"encoding/json"
 	"errors"
 	"math/rand"
 	"os"
@@ -12,6 +11,7 @@ import (
 	"github.com/rwinkhart/go-boilerplate/back"
 	"github.com/rwinkhart/go-boilerplate/security"
 	"github.com/rwinkhart/libmutton/global"
+	"github.com/rwinkhart/libmutton/history"
 	"github.com/rwinkhart/libmutton/synccommon"
 )
 
@@ -22,49 +22,59 @@ import (
 // the intended interface for shearing (ShearLocal should only
 // be used directly by the server binary).
 func ShearRemote(vanityPath string, onlyShearAgeFile bool) error {
-	deviceID, isDir, err := synccommon.ShearLocal(vanityPath, "", onlyShearAgeFile) // remove the target from the local system and get the device ID of the client
-	if err != nil {
-		return errors.New("unable to shear target locally: " + err.Error())
+	if err := ShearTargetsRemote([]string{vanityPath}, onlyShearAgeFile); err != nil {
+		return err
+	}
+	back.Exit(0) // sync is not required after shearing since the target has already been removed from the local system
+	return nil
+}
+
+// ShearTargetsRemote shears multiple targets (see ShearRemote) using a single connection.
+// Unlike ShearRemote, it does not exit the program, so it can be used as part of larger operations (e.g. imports).
+func ShearTargetsRemote(vanityPaths []string, onlyShearAgeFiles bool) error {
+	var deviceID string
+	remoteVanityPaths := make([]string, len(vanityPaths))
+	for i, vanityPath := range vanityPaths {
+		var isDir bool
+		var err error
+		deviceID, isDir, err = synccommon.ShearLocal(vanityPath, "", onlyShearAgeFiles) // remove the target from the local system and get the device ID of the client
+		if err != nil {
+			return errors.New("unable to shear target locally: " + err.Error())
+		}
+		if !onlyShearAgeFiles {
+			if err = history.Remove(vanityPath); err != nil {
+				return errors.New("unable to shear target locally: " + err.Error())
+			}
+		}
+		// ensure vanityPath ends with a slash if it is a directory (for clarity in shear message)
+		if isDir && !strings.HasSuffix(vanityPath, "/") {
+			vanityPath += "/"
+		}
+		remoteVanityPaths[i] = vanityPath
 	}
 
-	var modifier string
-	var output []byte
-	sshClient, offlineMode, _, _, _, err := GetSSHClient()
+	transport, offlineMode, err := getTransport()
 	if offlineMode {
-		goto end
+		return nil
 	}
 	if err != nil {
-		return errors.New("unable to connect to SSH client: " + err.Error())
+		return errors.New("unable to connect to server: " + err.Error())
 	}
 	if deviceID == "" {
+		_ = transport.Close()
 		return errors.New("unable to shear target remotely: no device ID found")
 	}
 
-	// ensure vanityPath ends with a slash if it is a directory (for clarity in shear message)
-	if isDir && !strings.HasSuffix(vanityPath, "/") {
-		vanityPath += "/"
-	}
-
-	// call the server to remotely shear the target and add it to the deletions list
-	if onlyShearAgeFile {
-		modifier = "-age"
-	}
-	output, err = GetSSHOutput(sshClient, "libmuttonserver shear"+modifier, deviceID+"\n"+strings.ReplaceAll(vanityPath, global.PathSeparator, global.FSPath))
-	if err != nil {
-		return errors.New("unable to shear target remotely: " + err.Error())
-	}
-	if len(output) > 11 {
-		return errors.New("unable to complete shear; server-side error occurred: " + strings.ReplaceAll(string(output)[11:len(output)-2], global.FSSpace, "\n"))
-	}
-
-	// close the SSH client
-	if err = sshClient.Close(); err != nil {
-		return errors.New("unable to close SSH client: " + err.Error())
+	// call the server to remotely shear the targets and add them to the deletions list
+	for _, vanityPath := range remoteVanityPaths {
+		if err = transport.Shear(deviceID, vanityPath, onlyShearAgeFiles); err != nil {
+			_ = transport.Close()
+			return errors.New("unable to shear target remotely: " + err.Error())
+		}
 	}
 
-end:
-	back.Exit(0) // sync is not required after shearing since the target has already been removed from the local system
-	return nil
+	// close the transport
+	return transport.Close()
 }
 
 // RenameRemote renames oldVanityPath to newVanityPath on
@@ -73,49 +83,50 @@ end:
 // It can safely be called in offline mode, as well, so this is the intended
 // interface for renaming (RenameLocal should only be used directly by the server binary).
 func RenameRemote(oldVanityPath, newVanityPath string) error {
+	if err := renameRemote(oldVanityPath, newVanityPath); err != nil {
+		return err
+	}
+	back.Exit(0)
+	return nil
+}
+
+// renameRemote is the non-exiting implementation of RenameRemote.
+func renameRemote(oldVanityPath, newVanityPath string) error {
 	// move the target on the local system
 	if err := synccommon.RenameLocal(oldVanityPath, newVanityPath); err != nil {
 		return errors.New("unable to rename target locally: " + err.Error())
 	}
-
-	deviceIDList, err := global.GenDeviceIDList()
-	if err != nil {
-		return errors.New("unable to generate device ID list: " + err.Error())
-	}
-	if deviceIDList[0].Name() == "" {
-		return errors.New("unable to rename target remotely: no device ID found")
+	if err := history.Rename(oldVanityPath, newVanityPath); err != nil {
+		return errors.New("unable to rename target locally: " + err.Error())
 	}
 
-	// create an SSH client
-	var output []byte
-	sshClient, offlineMode, _, _, _, err := GetSSHClient()
+	// connect to the server
+	transport, offlineMode, err := getTransport()
 	if offlineMode {
-		goto end
+		return nil
 	}
 	if err != nil {
-		return errors.New("unable to connect to SSH client: " + err.Error())
+		return errors.New("unable to connect to server: " + err.Error())
 	}
 
-	// call the server to move the target on the remote system and add the old target to the deletions list
-	output, err = GetSSHOutput(sshClient, "libmuttonserver rename",
-		(deviceIDList)[0].Name()+"\n"+
-			strings.ReplaceAll(oldVanityPath, global.PathSeparator, global.FSPath)+"\n"+
-			strings.ReplaceAll(newVanityPath, global.PathSeparator, global.FSPath))
+	deviceIDList, err := global.GenDeviceIDList()
 	if err != nil {
-		return errors.New("unable to rename target remotely: " + err.Error())
+		_ = transport.Close()
+		return errors.New("unable to generate device ID list: " + err.Error())
 	}
-	if len(output) > 11 {
-		return errors.New("unable to complete rename; server-side error occurred: " + strings.ReplaceAll(string(output)[11:len(output)-2], global.FSSpace, "\n"))
+	if len(deviceIDList) == 0 {
+		_ = transport.Close()
+		return errors.New("unable to rename target remotely: no device ID found")
 	}
 
-	// close the SSH client
-	if err = sshClient.Close(); err != nil {
-		return errors.New("unable to close SSH client: " + err.Error())
+	// call the server to move the target on the remote system and add the old target to the deletions list
+	if err = transport.Rename(deviceIDList[0].Name(), oldVanityPath, newVanityPath); err != nil {
+		_ = transport.Close()
+		return errors.New("unable to rename target remotely: " + err.Error())
 	}
 
-end:
-	back.Exit(0)
-	return nil
+	// close the transport
+	return transport.Close()
 }
 
 // AddFolderRemote creates a new entry-containing directory
@@ -124,38 +135,44 @@ end:
 // intended interface for adding folders (AddFolderLocal should only be
 // used directly by the server binary).
 func AddFolderRemote(vanityPath string) error {
-	// add the folder on the local system
-	if err := synccommon.AddFolderLocal(vanityPath); err != nil {
-		return errors.New("unable to add folder locally: " + err.Error())
+	if err := AddFoldersRemote([]string{vanityPath}); err != nil {
+		return err
 	}
+	back.Exit(0)
+	return nil
+}
 
-	// create an SSH client
-	var output []byte
-	sshClient, offlineMode, _, _, _, err := GetSSHClient()
-	if offlineMode {
-		goto end
-	}
-	if err != nil {
-		return errors.New("unable to connect to SSH client: " + err.Error())
+// AddFoldersRemote creates multiple entry-containing directories on the local system
+// (in the order given, so parents must precede their children) and calls the server
+// to create them remotely, using a single connection.
+// Unlike AddFolderRemote, it does not exit the program, so it can be used as part of larger operations (e.g. imports).
+func AddFoldersRemote(vanityPaths []string) error {
+	// add the folders on the local system
+	for _, vanityPath := range vanityPaths {
+		if err := synccommon.AddFolderLocal(vanityPath); err != nil {
+			return errors.New("unable to add folder locally: " + err.Error())
+		}
 	}
 
-	// call the server to create the folder remotely
-	output, err = GetSSHOutput(sshClient, "libmuttonserver addfolder", strings.ReplaceAll(vanityPath, global.PathSeparator, global.FSPath)) // call the server to create the folder remotely
-	if err != nil {
-		return errors.New("unable to add folder remotely: " + err.Error())
+	// connect to the server
+	transport, offlineMode, err := getTransport()
+	if offlineMode {
+		return nil
 	}
-	if len(output) > 11 {
-		return errors.New("unable to complete addfolder; server-side error occurred: " + strings.ReplaceAll(string(output)[11:len(output)-2], global.FSSpace, "\n"))
+	if err != nil {
+		return errors.New("unable to connect to server: " + err.Error())
 	}
 
-	// close the SSH client
-	if err = sshClient.Close(); err != nil {
-		return errors.New("unable to close SSH client: " + err.Error())
+	// call the server to create the folders remotely
+	for _, vanityPath := range vanityPaths {
+		if err = transport.AddFolder(vanityPath); err != nil {
+			_ = transport.Close()
+			return errors.New("unable to add folder remotely: " + err.Error())
+		}
 	}
 
-end:
-	back.Exit(0)
-	return nil
+	// close the transport
+	return transport.Close()
 }
 
 // GenDeviceID generates a new client device ID
@@ -198,30 +215,20 @@ func GenDeviceID(oldDeviceID *string, prefix string) (string, string, bool, erro
 	// register new device ID with server and fetch remote EntryRoot and OS type
 	// also removes the old device ID file (remotely)
 	// if registration fails, remove the new device ID file locally and return before removing the old one
-	sshClient, _, _, _, _, err := GetSSHClient()
-	if err != nil {
-		cleanupOnFail()
-		return "", "", false, errors.New("unable to connect to SSH client: " + err.Error())
+	transport, offlineMode, err := getTransport()
+	if err == nil && offlineMode {
+		err = errors.New("offline mode is enabled")
 	}
-	registerReqBytes, err := json.Marshal(synccommon.RegisterReqT{NewDeviceID: newDeviceID, OldDeviceID: oldDeviceID})
 	if err != nil {
-		return "", "", false, errors.New("unable to marshal client register request: " + err.Error())
+		cleanupOnFail()
+		return "", "", false, errors.New("unable to connect to server: " + err.Error())
 	}
-	output, err := GetSSHOutput(sshClient, "libmuttonserver register", string(registerReqBytes))
+	registerResp, err := transport.Register(&synccommon.RegisterReqT{NewDeviceID: newDeviceID, OldDeviceID: oldDeviceID})
+	_ = transport.Close() // ignore error; non-critical/unlikely/not much could be done about it
 	if err != nil {
 		cleanupOnFail()
 		return "", "", false, errors.New("unable to register device ID with server: " + err.Error())
 	}
-	var registerResp synccommon.RegisterRespT
-	if err = json.Unmarshal(output, &registerResp); err != nil {
-		cleanupOnFail()
-		return "", "", false, errors.New("unable to unmarshal server register response: " + err.Error())
-	}
-	if registerResp.ErrMsg != nil {
-		cleanupOnFail()
-		return "", "", false, errors.New("unable to complete register; server-side error occurred: " + strings.ReplaceAll(*registerResp.ErrMsg, global.FSSpace, "\n"))
-	}
-	_ = sshClient.Close() // ignore error; non-critical/unlikely/not much could be done about it
 
 	// remove old device ID file (locally; may not exist)
 	if oldDeviceID != nil {
@@ -0,0 +1,103 @@
+package syncclient
+
+import (
+	"encoding/json"
+	"errors"
+	"maps"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/rwinkhart/libmutton/global"
+	"github.com/rwinkhart/libmutton/synccommon"
+)
+
+// The remote cache stores the server's entry data as of the last fetch, along with the
+// server's change journal cursor. It allows the server to respond to fetches with only the
+// entries changed since the last fetch, which are then merged into the cache (see applyFetchResp).
+// A full fetch is still forced periodically (see getFetchCursor), so that any drift between the cache and
+// the server (e.g. from changes made on the server without going through its change journal) is corrected.
+
+const (
+	maxIncrementalFetches = 50               // number of incremental fetches after which a full fetch is forced
+	maxRemoteCacheAge     = 7 * 24 * 60 * 60 // age (in seconds) of the last full fetch after which a full fetch is forced
+)
+
+// remoteCacheT is the structure of the remote cache file.
+type remoteCacheT struct {
+	Cursor             string               `json:"cursor"`
+	Entries            synccommon.EntryMapT `json:"entries"`
+	IncrementalFetches int                  `json:"incrementalFetches"` // incremental fetches since the last full fetch
+	FullFetchTime      int64                `json:"fullFetchTime"`      // UNIX timestamp of the last full fetch
+}
+
+// getRemoteCachePath returns the path to the remote cache file.
+func getRemoteCachePath() string {
+	return global.CfgDir + global.PathSeparator + "syncremote.json"
+}
+
+// loadRemoteCache returns the remote cache saved by the last fetch (empty if there is none or it cannot be read,
+// in which case a full fetch is performed).
+func loadRemoteCache() *remoteCacheT {
+	var remoteCache remoteCacheT
+	remoteCacheBytes, err := os.ReadFile(getRemoteCachePath())
+	if err != nil || json.Unmarshal(remoteCacheBytes, &remoteCache) != nil || remoteCache.Entries == nil {
+		return &remoteCacheT{}
+	}
+	return &remoteCache
+}
+
+// getFetchCursor returns the cursor to fetch with (empty if a full fetch is due).
+func getFetchCursor(remoteCache *remoteCacheT) string {
+	if remoteCache.IncrementalFetches >= maxIncrementalFetches || time.Now().Unix()-remoteCache.FullFetchTime > maxRemoteCacheAge {
+		return ""
+	}
+	return remoteCache.Cursor
+}
+
+// saveRemoteCache atomically replaces the remote cache.
+func saveRemoteCache(remoteCache *remoteCacheT) error {
+	remoteCacheBytes, err := json.Marshal(remoteCache)
+	if err != nil {
+		return errors.New("unable to marshal remote cache: " + err.Error())
+	}
+	remoteCachePath := getRemoteCachePath()
+	if err = os.WriteFile(remoteCachePath+"-tmp", remoteCacheBytes, 0600); err != nil {
+		return errors.New("unable to write remote cache: " + err.Error())
+	}
+	if err = os.Rename(remoteCachePath+"-tmp", remoteCachePath); err != nil {
+		return errors.New("unable to write remote cache: " + err.Error())
+	}
+	return nil
+}
+
+// applyFetchResp updates the remote cache with the server's fetch response.
+// For incremental responses, fetchResp.Entries is replaced with the full (merged) set of remote entries.
+func applyFetchResp(remoteCache *remoteCacheT, fetchResp *synccommon.FetchRespT) {
+	if fetchResp.Incremental {
+		for _, vanityPath := range fetchResp.Removed {
+			// removed paths may be folders, in which case all contained entries are removed
+			vanityPath = strings.TrimRight(vanityPath, "/")
+			for cachedVanityPath := range remoteCache.Entries {
+				if cachedVanityPath == vanityPath || strings.HasPrefix(cachedVanityPath, vanityPath+"/") {
+					delete(remoteCache.Entries, cachedVanityPath)
+				}
+			}
+		}
+		for vanityPath, remoteInfo := range fetchResp.Entries {
+			remoteCache.Entries[vanityPath] = remoteInfo
+		}
+		remoteCache.IncrementalFetches++
+	} else {
+		remoteCache.Entries = fetchResp.Entries
+		if remoteCache.Entries == nil {
+			remoteCache.Entries = make(synccommon.EntryMapT)
+		}
+		remoteCache.IncrementalFetches = 0
+		remoteCache.FullFetchTime = time.Now().Unix()
+	}
+	remoteCache.Cursor = fetchResp.Cursor
+
+	// provide a copy, as the sync process removes entries from the remote entry map
+	fetchResp.Entries = maps.Clone(remoteCache.Entries)
+}
@@ -0,0 +1,338 @@
+package syncclient
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/pkg/sftp"
+	"github.com/rwinkhart/libmutton/config"
+	"github.com/rwinkhart/libmutton/global"
+	"github.com/rwinkhart/libmutton/privkey"
+	"github.com/rwinkhart/libmutton/synccommon"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// sshTransportT is the default TransportT implementation.
+// Commands are run on the server via the libmuttonserver binary over SSH,
+// and entries are transferred using SFTP.
+type sshTransportT struct {
+	sshClient    *ssh.Client
+	sftpClient   *sftp.Client // nil until the first transfer
+	sshEntryRoot string
+	sshAgeDir    string
+	sshIsWindows bool
+}
+
+// GetSSHTransport returns a TransportT backed by SSH/SFTP (see GetSSHClient).
+// Returns: transport (nil in offline mode), offlineMode.
+func GetSSHTransport() (TransportT, bool, error) {
+	sshClient, offlineMode, sshIsWindows, sshEntryRoot, sshAgeDir, err := GetSSHClient()
+	if offlineMode || err != nil {
+		return nil, offlineMode, err
+	}
+	transport := &sshTransportT{sshClient: sshClient}
+	// remote paths are not yet known during initial device ID registration
+	if sshEntryRoot != nil {
+		transport.sshEntryRoot = *sshEntryRoot
+	}
+	if sshAgeDir != nil {
+		transport.sshAgeDir = *sshAgeDir
+	}
+	if sshIsWindows != nil {
+		transport.sshIsWindows = *sshIsWindows
+	}
+	return transport, false, nil
+}
+
+// GetSSHClient
+// Returns:
+// sshClient,
+// offlineMode (whether the client is in offline mode).
+// sshIsWindows (whether the remote server is running Windows),
+// sshEntryRoot (the root directory for entries on the remote server),
+// Only supports key-based authentication (passwords are supported for CLI-based implementations).
+func GetSSHClient() (*ssh.Client, bool, *bool, *string, *string, error) {
+	// get SSH config info
+	cfg, err := config.Load()
+	if err != nil {
+		return nil, false, nil, nil, nil, errors.New("unable to parse SSH config: " + err.Error())
+	}
+	if *cfg.Libmutton.OfflineMode {
+		return nil, true, nil, nil, nil, nil
+	}
+
+	// get private key
+	key, err := privkey.GetBytes(cfg.Libmutton.SSHKeyPath)
+	if err != nil {
+		return nil, false, nil, nil, nil, err
+	}
+
+	// parse private key
+	var parsedKey ssh.Signer
+	if !*cfg.Libmutton.SSHKeyProtected {
+		parsedKey, err = ssh.ParsePrivateKey(key)
+	} else {
+		parsedKey, err = ssh.ParsePrivateKeyWithPassphrase(key, global.GetPassword("Enter password for your SSH keyfile:"))
+	}
+	if err != nil {
+		return nil, false, nil, nil, nil, errors.New("unable to parse private key: " + err.Error())
+	}
+
+	// read known hosts file
+	var hostKeyCallback ssh.HostKeyCallback
+	hostKeyCallback, err = knownhosts.New(global.SSHDir + global.PathSeparator + "known_hosts")
+	if err != nil {
+		return nil, false, nil, nil, nil, errors.New("unable to read known hosts file: " + err.Error())
+	}
+
+	// configure SSH client
+	sshCfg := &ssh.ClientConfig{
+		User: *cfg.Libmutton.SSHUser,
+		Auth: []ssh.AuthMethod{
+			ssh.PublicKeys(parsedKey),
+		},
+		HostKeyCallback: hostKeyCallback,
+		Timeout:         3 * time.Second,
+	}
+
+	// connect to SSH server
+	sshClient, err := ssh.Dial("tcp", *cfg.Libmutton.SSHIP+":"+*cfg.Libmutton.SSHPort, sshCfg)
+	if err != nil {
+		return nil, false, nil, nil, nil, errors.New("unable to connect to remote server: " + err.Error())
+	}
+
+	return sshClient, false, cfg.Libmutton.SSHIsWindows, cfg.Libmutton.SSHEntryRootPath, cfg.Libmutton.SSHAgeDirPath, nil
+}
+
+// GetSSHOutput runs a command over SSH and returns the output as a string.
+func GetSSHOutput(sshClient *ssh.Client, cmd, stdin string) ([]byte, error) {
+	// create a session
+	sshSession, err := sshClient.NewSession()
+	if err != nil {
+		return nil, errors.New("unable to establish SSH session: " + err.Error())
+	}
+
+	// provide stdin data for session
+	sshSession.Stdin = strings.NewReader(stdin)
+
+	// run the provided command
+	var output []byte
+	output, err = sshSession.CombinedOutput(cmd)
+	if err != nil {
+		return nil, errors.New("unable to run SSH command: " + err.Error())
+	}
+
+	return output, nil
+}
+
+// runServerCmd runs `libmuttonserver <cmd>` and returns an error if
+// a server-side error occurred (for commands that only output errors).
+func (t *sshTransportT) runServerCmd(cmd, stdin string) error {
+	output, err := GetSSHOutput(t.sshClient, "libmuttonserver "+cmd, stdin)
+	if err != nil {
+		return errors.New("unable to run remote command: " + err.Error())
+	}
+	if len(output) > 11 {
+		return errors.New("unable to complete " + cmd + "; server-side error occurred: " + strings.ReplaceAll(string(output)[11:len(output)-2], global.FSSpace, "\n"))
+	}
+	return nil
+}
+
+// getSFTPClient returns the transport's SFTP client, creating it if needed.
+func (t *sshTransportT) getSFTPClient() (*sftp.Client, error) {
+	if t.sftpClient == nil {
+		sftpClient, err := sftp.NewClient(t.sshClient)
+		if err != nil {
+			return nil, errors.New("unable to establish SFTP session: " + err.Error())
+		}
+		t.sftpClient = sftpClient
+	}
+	return t.sftpClient, nil
+}
+
+func (t *sshTransportT) Fetch(deviceID, cursor string) (*synccommon.FetchRespT, error) {
+	stdin := deviceID
+	if cursor != "" {
+		stdin += "\n" + cursor
+	}
+	output, err := GetSSHOutput(t.sshClient, "libmuttonserver fetch", stdin)
+	if err != nil {
+		return nil, errors.New("unable to run remote command: " + err.Error())
+	}
+	var fetchResp synccommon.FetchRespT
+	if err = json.Unmarshal(output, &fetchResp); err != nil {
+		fmt.Println(string(output))
+		return nil, errors.New("unable to unmarshal server fetch response: " + err.Error())
+	}
+	if fetchResp.ErrMsg != nil {
+		return nil, errors.New("unable to complete fetch; server-side error occurred: " + strings.ReplaceAll(*fetchResp.ErrMsg, global.FSSpace, "\n"))
+	}
+	return &fetchResp, nil
+}
+
+func (t *sshTransportT) Download(vanityPath string, dst io.Writer) (time.Time, error) {
+	sftpClient, err := t.getSFTPClient()
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	// store path to remote entry
+	remoteFileRealPath := getRealPathSFTP(vanityPath, t.sshEntryRoot, t.sshIsWindows)
+
+	// save modification time of remote file
+	fileInfo, err := sftpClient.Stat(remoteFileRealPath)
+	if err != nil {
+		return time.Time{}, errors.New("unable to get remote file info (mod time): " + err.Error())
+	}
+
+	// open remote file
+	remoteFile, err := sftpClient.Open(remoteFileRealPath)
+	if err != nil {
+		return time.Time{}, errors.New("unable to open remote file: " + err.Error())
+	}
+	defer func(remoteFile *sftp.File) {
+		_ = remoteFile.Close() // error ignored; if the file could be opened, it can probably be closed
+	}(remoteFile)
+
+	// download the file
+	if _, err = remoteFile.WriteTo(dst); err != nil {
+		return time.Time{}, errors.New("unable to download remote file: " + err.Error())
+	}
+	return fileInfo.ModTime(), nil
+}
+
+func (t *sshTransportT) Upload(vanityPath string, isAgeFile bool, src io.Reader, modTime time.Time) error {
+	sftpClient, err := t.getSFTPClient()
+	if err != nil {
+		return err
+	}
+
+	// store path to remote entry
+	var remoteFileRealPath string
+	if isAgeFile {
+		remoteFileRealPath = getRealAgePathSFTP(vanityPath, t.sshAgeDir, t.sshIsWindows)
+	} else {
+		remoteFileRealPath = getRealPathSFTP(vanityPath, t.sshEntryRoot, t.sshIsWindows)
+	}
+
+	// create remote file
+	remoteFile, err := sftpClient.OpenFile(remoteFileRealPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY)
+	if err != nil {
+		return errors.New("unable to create remote file: " + err.Error())
+	}
+
+	// upload the file
+	if _, err = remoteFile.ReadFrom(src); err != nil {
+		_ = remoteFile.Close()
+		return errors.New("unable to upload local file: " + err.Error())
+	}
+	_ = remoteFile.Close() // error ignored; if the file could be created, it can probably be closed
+
+	// set permissions on remote file
+	if err = sftpClient.Chmod(remoteFileRealPath, 0600); err != nil {
+		return errors.New("unable to set permissions on remote file: " + err.Error())
+	}
+
+	// set the modification time of the remote file to match the value saved from the local file (from before the upload)
+	if err = sftpClient.Chtimes(remoteFileRealPath, time.Now(), modTime); err != nil {
+		return errors.New("unable to set remote file modification time: " + err.Error())
+	}
+	return nil
+}
+
+func (t *sshTransportT) Touch(vanityPath string, modTime time.Time) error {
+	sftpClient, err := t.getSFTPClient()
+	if err != nil {
+		return err
+	}
+	if err = sftpClient.Chtimes(getRealPathSFTP(vanityPath, t.sshEntryRoot, t.sshIsWindows), time.Now(), modTime); err != nil {
+		return errors.New("unable to set remote file modification time: " + err.Error())
+	}
+	return nil
+}
+
+func (t *sshTransportT) ReportWrites(vanityPaths []string) error {
+	encodedPaths := make([]string, len(vanityPaths))
+	for i, vanityPath := range vanityPaths {
+		encodedPaths[i] = strings.ReplaceAll(vanityPath, "/", global.FSPath)
+	}
+	return t.runServerCmd("journal", strings.Join(encodedPaths, "\n"))
+}
+
+func (t *sshTransportT) Shear(deviceID, vanityPath string, onlyShearAgeFile bool) error {
+	cmd := "shear"
+	if onlyShearAgeFile {
+		cmd += "-age"
+	}
+	return t.runServerCmd(cmd, deviceID+"\n"+strings.ReplaceAll(vanityPath, "/", global.FSPath))
+}
+
+func (t *sshTransportT) Rename(deviceID, oldVanityPath, newVanityPath string) error {
+	return t.runServerCmd("rename",
+		deviceID+"\n"+
+			strings.ReplaceAll(oldVanityPath, "/", global.FSPath)+"\n"+
+			strings.ReplaceAll(newVanityPath, "/", global.FSPath))
+}
+
+func (t *sshTransportT) AddFolder(vanityPath string) error {
+	return t.runServerCmd("addfolder", strings.ReplaceAll(vanityPath, "/", global.FSPath))
+}
+
+func (t *sshTransportT) Register(registerReq *synccommon.RegisterReqT) (*synccommon.RegisterRespT, error) {
+	registerReqBytes, err := json.Marshal(registerReq)
+	if err != nil {
+		return nil, errors.New("unable to marshal client register request: " + err.Error())
+	}
+	output, err := GetSSHOutput(t.sshClient, "libmuttonserver register", string(registerReqBytes))
+	if err != nil {
+		return nil, errors.New("unable to run remote command: " + err.Error())
+	}
+	var registerResp synccommon.RegisterRespT
+	if err = json.Unmarshal(output, &registerResp); err != nil {
+		return nil, errors.New("unable to unmarshal server register response: " + err.Error())
+	}
+	if registerResp.ErrMsg != nil {
+		return nil, errors.New("unable to complete register; server-side error occurred: " + strings.ReplaceAll(*registerResp.ErrMsg, global.FSSpace, "\n"))
+	}
+	return &registerResp, nil
+}
+
+func (t *sshTransportT) Rekey(rekeyReq *synccommon.RekeyReqT) error {
+	rekeyReqBytes, err := json.Marshal(rekeyReq)
+	if err != nil {
+		return errors.New("unable to marshal client rekey request: " + err.Error())
+	}
+	return t.runServerCmd("rekey", string(rekeyReqBytes))
+}
+
+func (t *sshTransportT) Close() error {
+	if t.sftpClient != nil {
+		_ = t.sftpClient.Close() // error ignored; the SSH client is closed regardless
+	}
+	if err := t.sshClient.Close(); err != nil {
+		return errors.New("unable to close SSH client: " + err.Error())
+	}
+	return nil
+}
+
+// getRealPathSFTP formats the vanityPath to match the remote server's entry/age file directory and path separator.
+func getRealPathSFTP(vanityPath, serverEntryRoot string, serverIsWindows bool) string {
+	if !serverIsWindows {
+		return serverEntryRoot + vanityPath
+	}
+	return serverEntryRoot + strings.ReplaceAll(vanityPath, "/", "\\")
+}
+
+// getRealPathSFTP formats the vanityPath to match the remote server's entry/age file directory and path separator.
+func getRealAgePathSFTP(vanityPath, serverAgeDir string, serverIsWindows bool) string {
+	if !serverIsWindows {
+		return serverAgeDir + "/" + strings.ReplaceAll(vanityPath, "/", global.FSPath)
+	}
+	return serverAgeDir + "\\" + strings.ReplaceAll(vanityPath, "/", global.FSPath)
+}
@@ -0,0 +1,55 @@
+package syncclient
+
+import (
+	"io"
+	"time"
+
+	"github.com/rwinkhart/libmutton/synccommon"
+)
+
+// TransportT is implemented by each backend capable of communicating with a libmutton server.
+// Vanity paths are always passed in UNIX format.
+// The SSH/SFTP backend (see GetSSHTransport) is used by default.
+type TransportT interface {
+	// Fetch returns all information needed for syncing (see `libmuttonserver fetch`).
+	// If cursor is not empty, the server may respond with only the changes made since it (see synccommon.FetchRespT).
+	Fetch(deviceID, cursor string) (*synccommon.FetchRespT, error)
+	// Download writes the remote entry at vanityPath to dst.
+	// Returns: the modification time of the remote entry (from before the download).
+	Download(vanityPath string, dst io.Writer) (time.Time, error)
+	// Upload writes src to the remote entry (or age file, if isAgeFile is true) at vanityPath
+	// and sets its modification time to modTime.
+	Upload(vanityPath string, isAgeFile bool, src io.Reader, modTime time.Time) error
+	// Touch sets the modification time of the remote entry at vanityPath to modTime (without transferring it).
+	Touch(vanityPath string, modTime time.Time) error
+	// ReportWrites records entries (or their age files) written by Upload or Touch in the server's change journal.
+	// It must only be called if the server keeps a change journal (the fetch response contains a cursor).
+	ReportWrites(vanityPaths []string) error
+	// Shear removes the target (or only its age file) remotely and adds it to the deletions list.
+	Shear(deviceID, vanityPath string, onlyShearAgeFile bool) error
+	// Rename moves the target remotely and adds the old target to the deletions list.
+	Rename(deviceID, oldVanityPath, newVanityPath string) error
+	// AddFolder creates an entry-containing directory remotely.
+	AddFolder(vanityPath string) error
+	// Register registers a new device ID (see `libmuttonserver register`).
+	Register(registerReq *synccommon.RegisterReqT) (*synccommon.RegisterRespT, error)
+	// Rekey stores a new key generation and its RCW sanity check file remotely (see `libmuttonserver rekey`).
+	Rekey(rekeyReq *synccommon.RekeyReqT) error
+	// Close releases any resources held by the transport.
+	Close() error
+}
+
+var transportProvider = GetSSHTransport
+
+// getTransport returns a transport from the current provider.
+// Returns: transport (nil in offline mode), offlineMode.
+func getTransport() (TransportT, bool, error) {
+	return transportProvider()
+}
+
+// SetTransportProvider allows clients to replace the default
+// SSH/SFTP backend (e.g. with a different backend, or an in-memory one for testing).
+// provider must return a nil transport and true if the client is in offline mode.
+func SetTransportProvider(provider func() (TransportT, bool, error)) {
+	transportProvider = provider
+}
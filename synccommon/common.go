@@ -1,8 +1,11 @@
 package synccommon
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"io"
 	"os"
 	"strings"
 	"time"
@@ -18,20 +21,26 @@ const (
 
 // FetchRespT defines the structure of responses from `libmuttonserver fetch`.
 type FetchRespT struct {
-	ErrMsg     *string    `json:"errMsg"` // nil if no error occurred
-	ServerTime int64      `json:"serverTime"`
-	Deletions  []Deletion `json:"deletions"`
-	Entries    EntryMapT  `json:"entries"`
+	ErrMsg        *string    `json:"errMsg"` // nil if no error occurred
+	ServerTime    int64      `json:"serverTime"`
+	KeyGeneration *int64     `json:"keyGeneration"` // nil if the server does not track key generations
+	Sanity        []byte     `json:"sanity"`        // RCW sanity check file matching KeyGeneration (nil if the RCW password has never been changed)
+	Deletions     []Deletion `json:"deletions"`
+	Entries       EntryMapT  `json:"entries"`
+	Cursor        string     `json:"cursor"`      // position in the server's change journal as of this response (empty if the server does not keep one)
+	Incremental   bool       `json:"incremental"` // true if Entries only contains entries changed since the requested cursor
+	Removed       []string   `json:"removed"`     // vanity paths (of entries or folders) removed since the requested cursor (incremental responses only)
 }
 type Deletion struct {
 	VanityPath string `json:"vanityPath"`
 	IsAgeFile  bool   `json:"isAgeFile"`
 }
-type EntryMapT map[string]EntryT // map vanity paths to containing folders and mod+age timestamps
+type EntryMapT map[string]EntryT // map vanity paths to containing folders, mod+age timestamps, and hashes
 type EntryT struct {
 	ContainingFolder string `json:"containingFolder"`
 	ModTime          int64  `json:"modTime"`
 	AgeTimestamp     *int64 `json:"ageTimestamp"` // nil if no age file is present (non-password entry)
+	Hash             string `json:"hash"`         // hex-encoded SHA-256 hash of the (encrypted) entry; empty if not computed (see AddEntryHashes) or unknown (older servers)
 }
 
 // RegisterRespT defines the structure of responses from `libmuttonserver register`
@@ -49,12 +58,20 @@ type RegisterReqT struct {
 
 // GetAllEntryData returns a map of all vanity paths to
 // their respective containing folders and mod+age timestamps.
+// Hashes are left empty, as hashing every entry is slow (see AddEntryHashes).
 func GetAllEntryData() (EntryMapT, error) {
-	var err error
 	entryList, _, err := WalkEntryDir()
 	if err != nil {
 		return nil, errors.New("unable to walk entry directory: " + err.Error())
 	}
+	return GetEntryData(entryList)
+}
+
+// GetEntryData returns a map of the specified vanity paths to
+// their respective containing folders and mod+age timestamps.
+// Hashes are left empty, as hashing every entry is slow (see AddEntryHashes).
+func GetEntryData(entryList []string) (EntryMapT, error) {
+	var err error
 	// initialize vanityPath keys in map
 	outputEntries := make(EntryMapT)
 	var modInfo, ageInfo os.FileInfo
@@ -76,6 +93,36 @@ func GetAllEntryData() (EntryMapT, error) {
 	return outputEntries, nil
 }
 
+// AddEntryHashes sets the hash of every entry in entryMap (see GetEntryHash).
+func AddEntryHashes(entryMap EntryMapT) error {
+	for vanityPath, entry := range entryMap {
+		hash, err := GetEntryHash(vanityPath)
+		if err != nil {
+			return err
+		}
+		entry.Hash = hash
+		entryMap[vanityPath] = entry
+	}
+	return nil
+}
+
+// GetEntryHash returns the hex-encoded SHA-256 hash of the (encrypted) entry at vanityPath.
+// Equal hashes indicate identical ciphertexts (and therefore identical contents).
+func GetEntryHash(vanityPath string) (string, error) {
+	f, err := os.Open(global.GetRealPath(vanityPath))
+	if err != nil {
+		return "", errors.New("unable to open " + vanityPath + " for hashing: " + err.Error())
+	}
+	defer func(f *os.File) {
+		_ = f.Close() // error ignored; if the file could be opened, it can probably be closed
+	}(f)
+	hasher := sha256.New()
+	if _, err = io.Copy(hasher, f); err != nil {
+		return "", errors.New("unable to hash " + vanityPath + ": " + err.Error())
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
 // ShearLocal removes the target file or directory from the local system.
 // Returns: deviceID (only on client; for use in ShearRemoteFromClient),
 // isDir (only on client; for use in ShearRemoteFromClient).
@@ -93,6 +140,15 @@ func ShearLocal(vanityPath, clientDeviceID string, onlyShearAgeFile bool) (strin
 		return "", false, errors.New("unable to generate device ID list: " + err.Error())
 	}
 
+	// collect the attachments belonging to the target (they are sheared along with it)
+	var attachmentList []string
+	if !onlyShearAgeFile {
+		attachmentList, err = GetAttachmentList(vanityPath)
+		if err != nil {
+			return "", false, err
+		}
+	}
+
 	// add the sheared vanityPath to the deletions list (if running on a server)
 	if onServer {
 		for i := range deviceIDList {
@@ -105,6 +161,14 @@ func ShearLocal(vanityPath, clientDeviceID string, onlyShearAgeFile bool) (strin
 					}
 					_ = f.Close() // error ignored; if the file could be created, it can probably be closed
 				}
+				for _, attachment := range attachmentList {
+					f, err := os.OpenFile(global.CfgDir+global.PathSeparator+"deletions"+global.PathSeparator+deviceIDList[i].Name()+global.FSSpace+"entry"+global.FSSpace+strings.ReplaceAll(global.GetAttachmentVanityPath(vanityPath, attachment), "/", global.FSPath), os.O_CREATE|os.O_WRONLY, 0600)
+					if err != nil {
+						// failure to add the target to the deletions list will exit the program and result in a client re-uploading the target (non-critical)
+						return "", false, err
+					}
+					_ = f.Close() // error ignored; if the file could be created, it can probably be closed
+				}
 				f, err := os.OpenFile(global.CfgDir+global.PathSeparator+"deletions"+global.PathSeparator+deviceIDList[i].Name()+global.FSSpace+"age"+global.FSSpace+strings.ReplaceAll(vanityPath, "/", global.FSPath), os.O_CREATE|os.O_WRONLY, 0600)
 				if err != nil {
 					// failure to add the target to the deletions list will exit the program and result in a client re-uploading the target (non-critical)
@@ -131,6 +195,11 @@ func ShearLocal(vanityPath, clientDeviceID string, onlyShearAgeFile bool) (strin
 		if err = os.RemoveAll(realPath); err != nil {
 			return "", false, errors.New("unable to remove local entry (" + vanityPath + "): " + err.Error())
 		}
+		for _, attachment := range attachmentList {
+			if err = os.RemoveAll(global.GetRealPath(global.GetAttachmentVanityPath(vanityPath, attachment))); err != nil {
+				return "", false, errors.New("unable to remove local attachment (" + attachment + ") of " + vanityPath + ": " + err.Error())
+			}
+		}
 	}
 	if err = ShearAgeFileLocal(vanityPath); err != nil {
 		return "", false, err
@@ -173,6 +242,17 @@ func RenameLocal(oldVanityPath, newVanityPath string) error {
 		return errors.New("unable to rename: " + err.Error())
 	}
 
+	// do the same for any attachments
+	attachmentList, err := GetAttachmentList(oldVanityPath)
+	if err != nil {
+		return err
+	}
+	for _, attachment := range attachmentList {
+		if err = os.Rename(global.GetRealPath(global.GetAttachmentVanityPath(oldVanityPath, attachment)), global.GetRealPath(global.GetAttachmentVanityPath(newVanityPath, attachment))); err != nil {
+			return errors.New("unable to rename attachment (" + attachment + "): " + err.Error())
+		}
+	}
+
 	// do the same for the age file (if one exists) - also back up timestamp first
 	fileInfo, err := os.Stat(oldRealAgePath)
 	if err == nil { // assume age file does not exist if os.Stat errors
@@ -0,0 +1,59 @@
+package synccommon
+
+import (
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/rwinkhart/libmutton/global"
+)
+
+// The key generation is incremented each time the RCW password is changed (see core.EntryRefresh).
+// The server tracks the latest key generation (along with the matching RCW sanity check file)
+// so that other clients can detect that their entries and sanity check file are outdated.
+// A key generation of 0 indicates that the RCW password has never been changed.
+
+// RekeyReqT defines the structure of requests to `libmuttonserver rekey`.
+type RekeyReqT struct {
+	KeyGeneration int64  `json:"keyGeneration"`
+	Sanity        []byte `json:"sanity"` // RCW sanity check file matching KeyGeneration
+}
+
+// getKeyGenerationPath returns the path to the key generation file.
+func getKeyGenerationPath() string {
+	return global.CfgDir + global.PathSeparator + "keygen"
+}
+
+// GetKeyGeneration returns the current key generation (0 if it has never been set).
+func GetKeyGeneration() (int64, error) {
+	keyGenBytes, err := os.ReadFile(getKeyGenerationPath())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, errors.New("unable to read key generation: " + err.Error())
+	}
+	keyGeneration, err := strconv.ParseInt(strings.TrimSpace(string(keyGenBytes)), 10, 64)
+	if err != nil {
+		return 0, errors.New("unable to parse key generation: " + err.Error())
+	}
+	return keyGeneration, nil
+}
+
+// SetKeyGeneration sets the current key generation.
+func SetKeyGeneration(keyGeneration int64) error {
+	if err := os.WriteFile(getKeyGenerationPath(), []byte(strconv.FormatInt(keyGeneration, 10)), 0600); err != nil {
+		return errors.New("unable to write key generation: " + err.Error())
+	}
+	return nil
+}
+
+// StageKeyGeneration writes keyGeneration alongside the key generation file (with a "-new" suffix),
+// to be swapped in once an EntryRefresh completes (see global.RollForwardEntryRefresh).
+func StageKeyGeneration(keyGeneration int64) error {
+	if err := os.WriteFile(getKeyGenerationPath()+"-new", []byte(strconv.FormatInt(keyGeneration, 10)), 0600); err != nil {
+		return errors.New("unable to write key generation: " + err.Error())
+	}
+	return nil
+}
@@ -5,6 +5,7 @@ import (
 	"io/fs"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"github.com/rwinkhart/libmutton/global"
 )
@@ -45,3 +46,24 @@ func WalkEntryDir() ([]string, []string, error) {
 	}
 	return fileList, dirList, nil
 }
+
+// GetAttachmentList returns the names of all attachments
+// belonging to the entry at vanityPath (sorted by name).
+func GetAttachmentList(vanityPath string) ([]string, error) {
+	realPath := global.GetRealPath(vanityPath)
+	siblingList, err := os.ReadDir(filepath.Dir(realPath))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, errors.New("unable to read containing directory of " + vanityPath + ": " + err.Error())
+	}
+	prefix := filepath.Base(realPath) + global.FSMisc
+	var attachmentList []string
+	for _, sibling := range siblingList {
+		if !sibling.IsDir() && strings.HasPrefix(sibling.Name(), prefix) {
+			attachmentList = append(attachmentList, sibling.Name()[len(prefix):])
+		}
+	}
+	return attachmentList, nil
+}
@@ -0,0 +1,261 @@
+package syncserver
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rwinkhart/libmutton/global"
+)
+
+// The change journal is an append-only log of all changes made to the server's entries,
+// used to serve incremental fetches (see GetRemoteDataFromServer).
+// Its first line is a header (journalHeaderT) and each following line is a record (JournalRecordT).
+// Clients track their position in the journal with a cursor ("<journal ID>:<byte offset>").
+// Once the journal exceeds journalMaxSize, it is restarted with a new journal ID,
+// which causes clients with cursors into the old journal to fall back to a full fetch.
+// Entries are uploaded over SFTP, so clients must report their writes (see `libmuttonserver journal`).
+
+// JournalOpT is the type of change recorded in the change journal.
+type JournalOpT string
+
+const (
+	JournalOpWrite     JournalOpT = "write"     // entry (or its age file) written by a client
+	JournalOpShear     JournalOpT = "shear"     // entry, folder, or age file removed
+	JournalOpRename    JournalOpT = "rename"    // entry or folder moved from VanityPath to NewVanityPath
+	JournalOpAddFolder JournalOpT = "addfolder" // folder created
+)
+
+const (
+	journalMaxSize           = 4 << 20 // bytes
+	journalMaxAppendAttempts = 10      // attempts to append records to a journal that is concurrently being restarted
+)
+
+// JournalRecordT is a single change recorded in the change journal.
+type JournalRecordT struct {
+	Op            JournalOpT `json:"op"`
+	VanityPath    string     `json:"vanityPath"`
+	NewVanityPath string     `json:"newVanityPath,omitempty"` // JournalOpRename only
+}
+
+// journalHeaderT is the first line of the change journal.
+type journalHeaderT struct {
+	JournalID string `json:"journalID"`
+}
+
+// getJournalPath returns the path to the change journal.
+func getJournalPath() string {
+	return global.CfgDir + global.PathSeparator + "journal"
+}
+
+// AppendJournal appends records to the change journal, creating (or restarting) it if needed.
+func AppendJournal(records ...JournalRecordT) error {
+	var recordBytes []byte
+	for _, record := range records {
+		recordLine, err := json.Marshal(record)
+		if err != nil {
+			return errors.New("unable to marshal journal record: " + err.Error())
+		}
+		recordBytes = append(append(recordBytes, recordLine...), '\n')
+	}
+
+	// the journal may be restarted by another process at any time, in which case records appended to the
+	// replaced journal are lost; this is detected after each append (by comparing the open journal with the one
+	// at journalPath), and the records are appended again to the new journal (duplicate records are harmless)
+	journalPath := getJournalPath()
+	for range journalMaxAppendAttempts {
+		fileInfo, err := os.Stat(journalPath)
+		if err != nil || fileInfo.Size() > journalMaxSize {
+			if err = newJournal(err == nil); err != nil {
+				return err
+			}
+		}
+		replaced, err := appendJournalFile(journalPath, recordBytes)
+		if err != nil {
+			return err
+		}
+		if !replaced {
+			return nil
+		}
+	}
+	return errors.New("unable to write to journal: journal was repeatedly restarted during write")
+}
+
+// appendJournalFile appends recordBytes to the journal at journalPath.
+// Returns: whether the journal was replaced (see newJournal) while it was open,
+// in which case the records may not have been written to the current journal.
+func appendJournalFile(journalPath string, recordBytes []byte) (bool, error) {
+	// records are written in a single append so that concurrent writers cannot interleave them
+	f, err := os.OpenFile(journalPath, os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return true, nil // removed after it was checked; recreated by the caller
+		}
+		return false, errors.New("unable to open journal: " + err.Error())
+	}
+	if _, err = f.Write(recordBytes); err != nil {
+		_ = f.Close()
+		return false, errors.New("unable to write to journal: " + err.Error())
+	}
+	openInfo, err := f.Stat()
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return false, errors.New("unable to close journal: " + err.Error())
+	}
+	currentInfo, err := os.Stat(journalPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return true, nil
+		}
+		return false, errors.New("unable to stat journal: " + err.Error())
+	}
+	return !os.SameFile(openInfo, currentInfo), nil
+}
+
+// newJournal creates an empty change journal with a new journal ID.
+// An existing journal is only replaced if replace is true.
+func newJournal(replace bool) error {
+	headerBytes, err := json.Marshal(journalHeaderT{JournalID: strconv.FormatInt(time.Now().UnixNano(), 10)})
+	if err != nil {
+		return errors.New("unable to marshal journal header: " + err.Error())
+	}
+	journalPath := getJournalPath()
+	tmpPath := journalPath + "-" + strconv.Itoa(os.Getpid())
+	if err = os.WriteFile(tmpPath, append(headerBytes, '\n'), 0600); err != nil {
+		return errors.New("unable to create journal: " + err.Error())
+	}
+	defer func(tmpPath string) {
+		_ = os.RemoveAll(tmpPath)
+	}(tmpPath)
+	if replace {
+		err = os.Rename(tmpPath, journalPath)
+	} else if err = os.Link(tmpPath, journalPath); os.IsExist(err) {
+		err = nil // created concurrently by another process
+	}
+	if err != nil {
+		return errors.New("unable to create journal: " + err.Error())
+	}
+	return nil
+}
+
+// readJournal returns the vanity paths affected by all changes recorded after cursor
+// and the cursor pointing to the end of the journal.
+// If cursor is empty or invalid (e.g. from a restarted journal), only the new cursor is returned and ok is false.
+// Returns: changedPaths, newCursor, ok.
+func readJournal(cursor string) ([]string, string, bool, error) {
+	f, err := os.Open(getJournalPath())
+	if os.IsNotExist(err) {
+		if err = newJournal(false); err != nil {
+			return nil, "", false, err
+		}
+		f, err = os.Open(getJournalPath())
+	}
+	if err != nil {
+		return nil, "", false, errors.New("unable to open journal: " + err.Error())
+	}
+	defer func(f *os.File) {
+		_ = f.Close() // error ignored; if the file could be opened, it can probably be closed
+	}(f)
+
+	// read header
+	reader := bufio.NewReader(f)
+	headerLine, err := reader.ReadBytes('\n')
+	if err != nil {
+		return nil, "", false, errors.New("unable to read journal header: " + err.Error())
+	}
+	var header journalHeaderT
+	if err = json.Unmarshal(headerLine, &header); err != nil {
+		return nil, "", false, errors.New("unable to unmarshal journal header: " + err.Error())
+	}
+
+	// determine where to start reading records
+	fileInfo, err := f.Stat()
+	if err != nil {
+		return nil, "", false, errors.New("unable to stat journal: " + err.Error())
+	}
+	offset := int64(len(headerLine))
+	journalID, offsetString, _ := strings.Cut(cursor, ":")
+	cursorOffset, err := strconv.ParseInt(offsetString, 10, 64)
+	ok := err == nil && journalID == header.JournalID && cursorOffset >= offset && cursorOffset <= fileInfo.Size()
+	if ok {
+		if _, err = f.Seek(cursorOffset, io.SeekStart); err != nil {
+			return nil, "", false, errors.New("unable to seek journal: " + err.Error())
+		}
+		reader.Reset(f)
+		offset = cursorOffset
+	}
+
+	// read records (only complete lines, as a record may be in the process of being written)
+	var changedPaths []string
+	for {
+		recordLine, err := reader.ReadBytes('\n')
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, "", false, errors.New("unable to read journal: " + err.Error())
+		}
+		offset += int64(len(recordLine))
+		if !ok {
+			continue // only the end of the journal is needed
+		}
+		var record JournalRecordT
+		if err = json.Unmarshal(bytes.TrimSpace(recordLine), &record); err != nil {
+			return nil, "", false, errors.New("unable to unmarshal journal record: " + err.Error())
+		}
+		changedPaths = append(changedPaths, record.VanityPath)
+		if record.NewVanityPath != "" {
+			changedPaths = append(changedPaths, record.NewVanityPath)
+		}
+	}
+	return changedPaths, header.JournalID + ":" + strconv.FormatInt(offset, 10), ok, nil
+}
+
+// resolveChangedPaths sorts the vanity paths affected by journaled changes into
+// the entries that currently exist (including all entries within affected folders)
+// and the entries/folders that no longer exist.
+func resolveChangedPaths(changedPaths []string) ([]string, []string, error) {
+	var entryList, removedList []string
+	seen := make(map[string]bool)
+	for _, vanityPath := range changedPaths {
+		if seen[vanityPath] {
+			continue
+		}
+		seen[vanityPath] = true
+		fileInfo, err := os.Stat(global.GetRealPath(vanityPath))
+		if err != nil {
+			if os.IsNotExist(err) {
+				removedList = append(removedList, vanityPath)
+				continue
+			}
+			return nil, nil, errors.New("unable to stat " + vanityPath + ": " + err.Error())
+		}
+		if !fileInfo.IsDir() {
+			entryList = append(entryList, vanityPath)
+			continue
+		}
+		err = filepath.WalkDir(global.GetRealPath(vanityPath), func(realPath string, entry fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if !entry.IsDir() {
+				entryList = append(entryList, global.GetVanityPath(realPath))
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, nil, errors.New("unable to walk " + vanityPath + ": " + err.Error())
+		}
+	}
+	return entryList, removedList, nil
+}
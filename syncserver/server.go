@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
+	"strconv"
 	"strings"
 	"time"
 
@@ -12,10 +13,32 @@ import (
 )
 
 // GetRemoteDataFromServer prints to stdout the remote entries, mod times, folders, and deletions.
+// If cursor is valid (see readJournal), only the entries changed since it are included;
+// otherwise (e.g. if cursor is empty), all entries are included.
 // Output is meant to be captured over SSH for interpretation by the client.
-func GetRemoteDataFromServer(clientDeviceID string) {
+func GetRemoteDataFromServer(clientDeviceID, cursor string) {
+	// read the change journal first, so that changes made while collecting info are included in the next fetch
+	changedPaths, cursor, incremental, err := readJournal(cursor)
+	if err != nil {
+		fmt.Printf("{\"errMsg\":\"%s\"}", err.Error())
+		return
+	}
+
 	// collect info
-	entryMap, err := synccommon.GetAllEntryData()
+	var entryMap synccommon.EntryMapT
+	var removedList []string
+	if incremental {
+		var entryList []string
+		if entryList, removedList, err = resolveChangedPaths(changedPaths); err == nil {
+			entryMap, err = synccommon.GetEntryData(entryList)
+		}
+	} else {
+		entryMap, err = synccommon.GetAllEntryData()
+	}
+	if err == nil {
+		// only the entries being sent are hashed (for incremental responses, only those changed since the cursor)
+		err = synccommon.AddEntryHashes(entryMap)
+	}
 	if err != nil {
 		fmt.Printf("{\"errMsg\":\"%s\"}", err.Error())
 		return
@@ -30,6 +53,19 @@ func GetRemoteDataFromServer(clientDeviceID string) {
 	var fetchResp synccommon.FetchRespT
 	//// server time
 	fetchResp.ServerTime = time.Now().Unix()
+	//// key generation
+	keyGeneration, err := synccommon.GetKeyGeneration()
+	if err != nil {
+		fmt.Printf("{\"errMsg\":\"%s\"}", err.Error())
+		return
+	}
+	fetchResp.KeyGeneration = &keyGeneration
+	if keyGeneration > 0 {
+		if fetchResp.Sanity, err = os.ReadFile(global.CfgDir + global.PathSeparator + "sanity.rcw"); err != nil {
+			fmt.Printf("{\"errMsg\":\"%s\"}", err.Error())
+			return
+		}
+	}
 	//// deletions
 	for i := range deletionsList {
 		// perform deletion if it is relevant to the current client device
@@ -50,6 +86,9 @@ func GetRemoteDataFromServer(clientDeviceID string) {
 	}
 	//// entries
 	fetchResp.Entries = entryMap
+	fetchResp.Cursor = cursor
+	fetchResp.Incremental = incremental
+	fetchResp.Removed = removedList
 
 	// marshal and print response to send to client
 	fetchRespBytes, err := json.Marshal(fetchResp)
@@ -59,3 +98,27 @@ func GetRemoteDataFromServer(clientDeviceID string) {
 	}
 	fmt.Print(string(fetchRespBytes))
 }
+
+// Rekey stores the RCW sanity check file for a new key generation (after the RCW password
+// was changed on a client), so that other clients can detect that their RCW password is outdated.
+// Requests for key generations that are not newer than the current one are rejected.
+// Errors are printed to stdout for interpretation by the client.
+func Rekey(rekeyReq *synccommon.RekeyReqT) {
+	keyGeneration, err := synccommon.GetKeyGeneration()
+	if err != nil {
+		fmt.Printf("{\"errMsg\":\"%s\"}", err.Error())
+		return
+	}
+	if rekeyReq.KeyGeneration <= keyGeneration {
+		fmt.Printf("{\"errMsg\":\"%s\"}", "key generation "+strconv.FormatInt(rekeyReq.KeyGeneration, 10)+" is outdated (server is at key generation "+strconv.FormatInt(keyGeneration, 10)+"); the RCW password was likely changed on multiple devices")
+		return
+	}
+	if err = os.WriteFile(global.CfgDir+global.PathSeparator+"sanity.rcw", rekeyReq.Sanity, 0600); err != nil {
+		fmt.Printf("{\"errMsg\":\"%s\"}", err.Error())
+		return
+	}
+	if err = synccommon.SetKeyGeneration(rekeyReq.KeyGeneration); err != nil {
+		fmt.Printf("{\"errMsg\":\"%s\"}", err.Error())
+		return
+	}
+}